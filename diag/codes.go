@@ -0,0 +1,77 @@
+package diag
+
+// Codes describes every diagnostic code. Codes are stable: once published a
+// code keeps its meaning and is never reused. See docs/errors.md.
+var Codes = map[string]string{
+	// Lexer
+	"E0101": "unterminated string literal",
+	"E0102": "invalid escape sequence in string literal",
+	"E0103": "unterminated raw string literal",
+	"E0104": "malformed number literal",
+	"E0105": "number literal out of range",
+	"E0106": "unterminated block comment",
+	"E0107": "invalid UTF-8 encoding",
+	"E0108": "unexpected character",
+	"E0109": "source could not be read",
+
+	// Parser
+	"E0200": "too many errors",
+	"E0201": "unexpected token at top level",
+	"E0202": "expected expression",
+	"E0203": "missing ; after statement",
+	"E0204": "expected identifier in assignment",
+	"E0205": "expected = in assignment",
+	"E0206": "missing ; after extern prototype",
+	"E0207": "expected function return type",
+	"E0208": "expected function name",
+	"E0209": "expected ( after function name",
+	"E0210": "expected , or ) in parameter list",
+	"E0211": "expected parameter type",
+	"E0212": "expected parameter name",
+	"E0213": "expected { to start block",
+	"E0214": "expected , or ) in argument list",
+	"E0215": "expected ) to close parenthesised expression",
+	"E0216": "expected binary operator",
+	"E0217": "code nested too deeply",
+	"E0218": "invalid unary operator definition",
+	"E0219": "expected .. in range",
+	"E0220": "expected ; in for loop",
+	"E0221": "expected loop after label",
+	"E0222": "expected } after if expression branch",
+	"E0223": "expected else in if expression",
+	"E0224": "expected type",
+	"E0225": "mismatched type annotation in set statement",
+
+	// Code generation
+	"E0301": "function defined more than once",
+	"E0302": "missing return in non-void function",
+	"E0303": "function call outside a function",
+	"E0304": "unknown function",
+	"E0305": "wrong number of arguments",
+	"E0306": "binary expression outside a function",
+	"E0307": "mismatched operand types",
+	"E0308": "unsupported operand type",
+	"E0309": "unsupported operator for type",
+	"E0310": "string literal outside a function",
+	"E0311": "unknown constant",
+	"E0312": "unknown variable",
+	"E0313": "void value used as a value",
+	"E0314": "constant initialiser is not constant",
+	"E0315": "assignment to constant",
+	"E0316": "assignment to non-variable",
+	"E0317": "assignment of incompatible type",
+	"E0318": "conflicting function declarations",
+	"E0319": "unary expression outside a function",
+	"E0320": "invalid range bounds",
+	"E0321": "break or continue outside a loop",
+	"E0322": "unknown loop label",
+	"E0323": "mismatched if expression branches",
+	"E0324": "if expression outside a function",
+	"E0325": "invalid condition type",
+	"E0326": "assignment to undeclared variable",
+	"E0327": "assignment to immutable variable",
+	"E0328": "variable already declared",
+	"E0329": "mismatched declared type",
+	"E0330": "mismatched return type",
+	"E0331": "mismatched argument type",
+}
@@ -0,0 +1,103 @@
+package diag
+
+import (
+	"Kaleidoscope/lexer"
+	"fmt"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Span is a range of source code from Start up to but not including End. The
+// zero Span means the diagnostic has no location.
+type Span struct {
+	Start lexer.Pos
+	End   lexer.Pos
+}
+
+// TokenSpan returns the span covered by tok.
+func TokenSpan(tok lexer.Token) Span {
+	return Span{Start: tok.Start, End: tok.End}
+}
+
+func (s Span) IsValid() bool {
+	return s.Start.Line > 0
+}
+
+// Label attaches a message to a secondary span of a diagnostic.
+type Label struct {
+	Span    Span
+	Message string
+}
+
+// Fix is a suggested edit replacing the text of Span with Replacement.
+type Fix struct {
+	Span        Span
+	Replacement string
+	Message     string
+}
+
+// Diagnostic is an error, warning or note about the source being compiled.
+// Code identifies the kind of diagnostic, see Codes.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     Span
+	Labels   []Label
+	Notes    []string
+	Fix      *Fix
+}
+
+// Errorf creates an error diagnostic.
+func Errorf(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+func (d *Diagnostic) Error() string {
+	s := fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+	if d.Span.IsValid() {
+		s = d.Span.Start.String() + ": " + s
+	}
+	return s
+}
+
+// WithLabel adds a secondary label and returns d.
+func (d *Diagnostic) WithLabel(span Span, msg string) *Diagnostic {
+	d.Labels = append(d.Labels, Label{Span: span, Message: msg})
+	return d
+}
+
+// WithNote adds a note and returns d.
+func (d *Diagnostic) WithNote(note string) *Diagnostic {
+	d.Notes = append(d.Notes, note)
+	return d
+}
+
+// WithFix sets the suggested fix and returns d.
+func (d *Diagnostic) WithFix(span Span, replacement string, msg string) *Diagnostic {
+	d.Fix = &Fix{Span: span, Replacement: replacement, Message: msg}
+	return d
+}
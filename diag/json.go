@@ -0,0 +1,261 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// JSONVersion is the version of the schema written by WriteJSON. It changes
+// only when a field is removed or changes meaning; new fields may be added
+// without changing it. The schema is described in docs/errors.md.
+const JSONVersion = 1
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+type jsonRange struct {
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+type jsonRelated struct {
+	File    string     `json:"file,omitempty"`
+	Range   *jsonRange `json:"range,omitempty"`
+	Message string     `json:"message"`
+}
+
+type jsonFix struct {
+	File        string     `json:"file,omitempty"`
+	Range       *jsonRange `json:"range,omitempty"`
+	Replacement string     `json:"replacement"`
+	Message     string     `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	File     string        `json:"file,omitempty"`
+	Range    *jsonRange    `json:"range,omitempty"`
+	Severity string        `json:"severity"`
+	Code     string        `json:"code"`
+	Message  string        `json:"message"`
+	Related  []jsonRelated `json:"related,omitempty"`
+	Notes    []string      `json:"notes,omitempty"`
+	Fix      *jsonFix      `json:"fix,omitempty"`
+}
+
+type jsonOutput struct {
+	Version     int              `json:"version"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics"`
+}
+
+func toJSONRange(span Span) *jsonRange {
+	if !span.IsValid() {
+		return nil
+	}
+	return &jsonRange{
+		Start: jsonPos{Line: span.Start.Line, Column: span.Start.Column, Offset: span.Start.Offset},
+		End:   jsonPos{Line: span.End.Line, Column: span.End.Column, Offset: span.End.Offset},
+	}
+}
+
+// WriteJSON writes diags to w as a single JSON document.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	out := jsonOutput{Version: JSONVersion, Diagnostics: []jsonDiagnostic{}}
+	for _, d := range diags {
+		jd := jsonDiagnostic{
+			File:     d.Span.Start.File,
+			Range:    toJSONRange(d.Span),
+			Severity: d.Severity.String(),
+			Code:     d.Code,
+			Message:  d.Message,
+			Notes:    d.Notes,
+		}
+		for _, label := range d.Labels {
+			jd.Related = append(jd.Related, jsonRelated{
+				File:    label.Span.Start.File,
+				Range:   toJSONRange(label.Span),
+				Message: label.Message,
+			})
+		}
+		if d.Fix != nil {
+			jd.Fix = &jsonFix{
+				File:        d.Fix.Span.Start.File,
+				Range:       toJSONRange(d.Fix.Span),
+				Replacement: d.Fix.Replacement,
+				Message:     d.Fix.Message,
+			}
+		}
+		out.Diagnostics = append(out.Diagnostics, jd)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               *int                   `json:"id,omitempty"`
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+func toSARIFLocation(span Span) *sarifPhysicalLocation {
+	if !span.IsValid() {
+		return nil
+	}
+	return &sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: span.Start.File},
+		Region: &sarifRegion{
+			StartLine:   span.Start.Line,
+			StartColumn: span.Start.Column,
+			EndLine:     span.End.Line,
+			EndColumn:   span.End.Column,
+		},
+	}
+}
+
+// WriteSARIF writes diags to w as a SARIF 2.1.0 log produced by the tool
+// with the given name. Every code used becomes a rule described by Codes.
+func WriteSARIF(w io.Writer, tool string, diags []*Diagnostic) error {
+	var codes []string
+	seen := map[string]bool{}
+	for _, d := range diags {
+		if !seen[d.Code] {
+			seen[d.Code] = true
+			codes = append(codes, d.Code)
+		}
+	}
+	sort.Strings(codes)
+
+	run := sarifRun{ColumnKind: "unicodeCodePoints", Results: []sarifResult{}}
+	run.Tool.Driver = sarifDriver{Name: tool, Rules: []sarifRule{}}
+	ruleIndex := map[string]int{}
+	for i, code := range codes {
+		ruleIndex[code] = i
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               code,
+			ShortDescription: sarifMessage{Text: Codes[code]},
+		})
+	}
+
+	for _, d := range diags {
+		// Severity names match the SARIF levels.
+		result := sarifResult{
+			RuleID:    d.Code,
+			RuleIndex: ruleIndex[d.Code],
+			Level:     d.Severity.String(),
+			Message:   sarifMessage{Text: d.Message},
+		}
+		for _, note := range d.Notes {
+			result.Message.Text += "\nnote: " + note
+		}
+		if loc := toSARIFLocation(d.Span); loc != nil {
+			result.Locations = []sarifLocation{{PhysicalLocation: loc}}
+		}
+		for i, label := range d.Labels {
+			id := i
+			result.RelatedLocations = append(result.RelatedLocations, sarifLocation{
+				ID:               &id,
+				PhysicalLocation: toSARIFLocation(label.Span),
+				Message:          &sarifMessage{Text: label.Message},
+			})
+		}
+		if d.Fix != nil {
+			if loc := toSARIFLocation(d.Fix.Span); loc != nil {
+				result.Fixes = []sarifFix{{
+					Description: sarifMessage{Text: d.Fix.Message},
+					ArtifactChanges: []sarifArtifactChange{{
+						ArtifactLocation: loc.ArtifactLocation,
+						Replacements: []sarifReplacement{{
+							DeletedRegion:   *loc.Region,
+							InsertedContent: sarifMessage{Text: d.Fix.Replacement},
+						}},
+					}},
+				}}
+			}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,206 @@
+package diag
+
+import (
+	"Kaleidoscope/lexer"
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func span(line, col, offset, length int) Span {
+	return Span{
+		Start: lexer.Pos{File: "a.ks", Line: line, Column: col, Offset: offset},
+		End:   lexer.Pos{File: "a.ks", Line: line, Column: col + length, Offset: offset + length},
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		diags []*Diagnostic
+		want  []jsonDiagnostic
+	}{
+		{
+			name: "none",
+			want: []jsonDiagnostic{},
+		},
+		{
+			name: "without span",
+			diags: []*Diagnostic{
+				Errorf("E0200", Span{}, "too many errors, stopping after %d", 3),
+			},
+			want: []jsonDiagnostic{
+				{Severity: "error", Code: "E0200", Message: "too many errors, stopping after 3"},
+			},
+		},
+		{
+			name: "everything",
+			diags: []*Diagnostic{
+				Errorf("E0203", span(2, 5, 20, 3), "expected ; at end of statement").
+					WithLabel(span(1, 1, 0, 3), "statement starts here").
+					WithNote("statements end with ;").
+					WithFix(span(2, 8, 23, 0), ";", "add a semicolon"),
+				{Severity: Warning, Code: "E0300", Message: "warning"},
+			},
+			want: []jsonDiagnostic{
+				{
+					File:     "a.ks",
+					Range:    &jsonRange{Start: jsonPos{Line: 2, Column: 5, Offset: 20}, End: jsonPos{Line: 2, Column: 8, Offset: 23}},
+					Severity: "error",
+					Code:     "E0203",
+					Message:  "expected ; at end of statement",
+					Related: []jsonRelated{{
+						File:    "a.ks",
+						Range:   &jsonRange{Start: jsonPos{Line: 1, Column: 1}, End: jsonPos{Line: 1, Column: 4, Offset: 3}},
+						Message: "statement starts here",
+					}},
+					Notes: []string{"statements end with ;"},
+					Fix: &jsonFix{
+						File:        "a.ks",
+						Range:       &jsonRange{Start: jsonPos{Line: 2, Column: 8, Offset: 23}, End: jsonPos{Line: 2, Column: 8, Offset: 23}},
+						Replacement: ";",
+						Message:     "add a semicolon",
+					},
+				},
+				{Severity: "warning", Code: "E0300", Message: "warning"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteJSON(&buf, test.diags); err != nil {
+				t.Fatal(err)
+			}
+			var got jsonOutput
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != JSONVersion {
+				t.Errorf("got version %d, want %d", got.Version, JSONVersion)
+			}
+			if !reflect.DeepEqual(got.Diagnostics, test.want) {
+				t.Errorf("got:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	diags := []*Diagnostic{
+		Errorf("E0304", span(3, 2, 30, 4), "could not find function: fact").WithNote("declare it with extern"),
+		Errorf("E0203", span(2, 5, 20, 3), "expected ; at end of statement").
+			WithLabel(span(1, 1, 0, 3), "statement starts here").
+			WithFix(span(2, 8, 23, 0), ";", "add a semicolon"),
+		Errorf("E0304", span(4, 2, 40, 4), "could not find function: g"),
+		Errorf("E0200", Span{}, "too many errors"),
+	}
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, "kscc", diags); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("got version %s with %d runs, want 2.1.0 with 1", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "kscc" {
+		t.Errorf("got tool %s, want kscc", run.Tool.Driver.Name)
+	}
+
+	// Each code is a rule once, in order
+	wantRules := []sarifRule{
+		{ID: "E0200", ShortDescription: sarifMessage{Text: Codes["E0200"]}},
+		{ID: "E0203", ShortDescription: sarifMessage{Text: Codes["E0203"]}},
+		{ID: "E0304", ShortDescription: sarifMessage{Text: Codes["E0304"]}},
+	}
+	if !reflect.DeepEqual(run.Tool.Driver.Rules, wantRules) {
+		t.Errorf("got rules %+v, want %+v", run.Tool.Driver.Rules, wantRules)
+	}
+
+	region := func(line, col, length int) *sarifRegion {
+		return &sarifRegion{StartLine: line, StartColumn: col, EndLine: line, EndColumn: col + length}
+	}
+	location := func(line, col, length int) *sarifPhysicalLocation {
+		return &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: "a.ks"}, Region: region(line, col, length)}
+	}
+	id := 0
+	want := []sarifResult{
+		{
+			RuleID:    "E0304",
+			RuleIndex: 2,
+			Level:     "error",
+			Message:   sarifMessage{Text: "could not find function: fact\nnote: declare it with extern"},
+			Locations: []sarifLocation{{PhysicalLocation: location(3, 2, 4)}},
+		},
+		{
+			RuleID:    "E0203",
+			RuleIndex: 1,
+			Level:     "error",
+			Message:   sarifMessage{Text: "expected ; at end of statement"},
+			Locations: []sarifLocation{{PhysicalLocation: location(2, 5, 3)}},
+			RelatedLocations: []sarifLocation{{
+				ID:               &id,
+				PhysicalLocation: location(1, 1, 3),
+				Message:          &sarifMessage{Text: "statement starts here"},
+			}},
+			Fixes: []sarifFix{{
+				Description: sarifMessage{Text: "add a semicolon"},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: sarifArtifactLocation{URI: "a.ks"},
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   *region(2, 8, 0),
+						InsertedContent: sarifMessage{Text: ";"},
+					}},
+				}},
+			}},
+		},
+		{
+			RuleID:    "E0304",
+			RuleIndex: 2,
+			Level:     "error",
+			Message:   sarifMessage{Text: "could not find function: g"},
+			Locations: []sarifLocation{{PhysicalLocation: location(4, 2, 4)}},
+		},
+		{
+			RuleID:    "E0200",
+			RuleIndex: 0,
+			Level:     "error",
+			Message:   sarifMessage{Text: "too many errors"},
+		},
+	}
+	if !reflect.DeepEqual(run.Results, want) {
+		t.Errorf("got:\n%s", buf.String())
+	}
+}
+
+// TestCodesDocumented checks that docs/errors.md lists exactly the codes in
+// Codes, in order.
+func TestCodesDocumented(t *testing.T) {
+	doc, err := os.ReadFile("../docs/errors.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var documented []string
+	for _, m := range regexp.MustCompile(`(?m)^\| (E\d{4}) \|`).FindAllSubmatch(doc, -1) {
+		documented = append(documented, string(m[1]))
+	}
+	var codes []string
+	for code := range Codes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	if !sort.StringsAreSorted(documented) {
+		t.Errorf("codes in docs/errors.md are not in order")
+	}
+	if !reflect.DeepEqual(documented, codes) {
+		t.Errorf("docs/errors.md documents %v, but the codes are %v", documented, codes)
+	}
+}
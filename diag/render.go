@@ -0,0 +1,125 @@
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[1;31m"
+	colorYellow = "\x1b[1;33m"
+	colorBlue   = "\x1b[1;34m"
+	colorCyan   = "\x1b[1;36m"
+)
+
+// Renderer prints diagnostics for humans, showing the offending source line
+// with the span underlined.
+type Renderer struct {
+	// Sources maps file names to their contents
+	Sources map[string][]byte
+	// Color enables ANSI colours, for use when writing to a terminal
+	Color bool
+}
+
+// Render writes d to w.
+func (r *Renderer) Render(w io.Writer, d *Diagnostic) {
+	sevColor := colorRed
+	if d.Severity == Warning {
+		sevColor = colorYellow
+	} else if d.Severity == Note {
+		sevColor = colorCyan
+	}
+
+	fmt.Fprintf(w, "%s%s[%s]%s: %s%s%s\n",
+		r.color(sevColor), d.Severity, d.Code, r.color(colorReset),
+		r.color(colorBold), d.Message, r.color(colorReset))
+
+	// Width of the line number gutter
+	gutter := 0
+	for _, span := range r.spans(d) {
+		if width := len(strconv.Itoa(span.Start.Line)); width > gutter {
+			gutter = width
+		}
+	}
+	pad := strings.Repeat(" ", gutter)
+
+	if d.Span.IsValid() {
+		fmt.Fprintf(w, "%s%s-->%s %s\n", pad, r.color(colorBlue), r.color(colorReset), d.Span.Start)
+		r.renderSpan(w, gutter, d.Span, '^', sevColor, "")
+		for _, label := range d.Labels {
+			r.renderSpan(w, gutter, label.Span, '-', colorBlue, label.Message)
+		}
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(w, "%s %s=%s note: %s\n", pad, r.color(colorBlue), r.color(colorReset), note)
+	}
+	if d.Fix != nil {
+		fmt.Fprintf(w, "%s %s=%s help: %s: %q\n", pad, r.color(colorBlue), r.color(colorReset), d.Fix.Message, d.Fix.Replacement)
+	}
+}
+
+func (r *Renderer) spans(d *Diagnostic) []Span {
+	spans := []Span{d.Span}
+	for _, label := range d.Labels {
+		spans = append(spans, label.Span)
+	}
+	return spans
+}
+
+// renderSpan prints the first source line of span, underlined with mark.
+func (r *Renderer) renderSpan(w io.Writer, gutter int, span Span, mark byte, markColor string, msg string) {
+	src, ok := r.Sources[span.Start.File]
+	if !ok || !span.IsValid() || span.Start.Offset > len(src) {
+		return
+	}
+
+	lineStart := bytes.LastIndexByte(src[:span.Start.Offset], '\n') + 1
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[span.Start.Offset:], '\n'); i >= 0 {
+		lineEnd = span.Start.Offset + i
+	}
+	line := strings.TrimRight(string(src[lineStart:lineEnd]), "\r")
+
+	// Indent the underline with the same tabs as the source line
+	var indent strings.Builder
+	for _, chr := range string(src[lineStart:span.Start.Offset]) {
+		if chr == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	end := lineEnd
+	if span.End.Offset >= span.Start.Offset && span.End.Offset < lineEnd {
+		end = span.End.Offset
+	}
+	width := utf8.RuneCount(src[span.Start.Offset:end])
+	if width < 1 {
+		width = 1
+	}
+
+	pad := strings.Repeat(" ", gutter)
+	bar := r.color(colorBlue) + "|" + r.color(colorReset)
+	fmt.Fprintf(w, "%s %s\n", pad, bar)
+	fmt.Fprintf(w, "%s%*d |%s %s\n", r.color(colorBlue), gutter, span.Start.Line, r.color(colorReset), line)
+	fmt.Fprintf(w, "%s %s %s%s%s", pad, bar, indent.String(), r.color(markColor), strings.Repeat(string(mark), width))
+	if msg != "" {
+		fmt.Fprintf(w, " %s", msg)
+	}
+	fmt.Fprintf(w, "%s\n", r.color(colorReset))
+}
+
+func (r *Renderer) color(code string) string {
+	if !r.Color {
+		return ""
+	}
+	return code
+}
@@ -0,0 +1,221 @@
+// Package diff computes line based differences between two texts.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// op is a line of an edit script: ' ' keeps a line of the old text, '-'
+// deletes it and '+' inserts a line of the new text.
+type op struct {
+	kind byte
+	line string
+}
+
+// Unified returns the differences between old and new in unified diff format,
+// with the file names oldName and newName in its header, or nil if they are
+// equal.
+func Unified(oldName string, newName string, old []byte, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	ops := edits(splitLines(old), splitLines(new))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	// Line numbers in the old and new text at the start of ops[i]
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// The hunk starts with context before the change and ends after
+		// context lines that are not followed by another change soon enough
+		// to share them
+		start := i
+		for start > 0 && i-start < context && ops[start-1].kind == ' ' {
+			start--
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				if next-end > context {
+					next = end + context
+				}
+				end = next
+				break
+			}
+			end = next
+		}
+
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		for _, o := range ops[start:end] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, o := range ops[start:end] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			if len(o.line) == 0 || o.line[len(o.line)-1] != '\n' {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		for _, o := range ops[i:end] {
+			if o.kind != '+' {
+				oldLine++
+			}
+			if o.kind != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// hunkRange formats the range of count lines after the first start lines for
+// a hunk header. An empty range names the line before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits text into lines, keeping their newlines.
+func splitLines(text []byte) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		lines = append(lines, string(text[:i]))
+		text = text[i:]
+	}
+	return lines
+}
+
+// edits returns the shortest edit script turning a into b, using Myers'
+// algorithm.
+func edits(a []string, b []string) []op {
+	// Lines shared at the start and end need no search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []op
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+// myers finds the shortest edit script turning a into b. The furthest
+// reaching x on each diagonal k = x - y is kept for every number of edits d,
+// limited to the diagonals -d..d that it can reach, and the path is then
+// traced back from the end.
+func myers(a []string, b []string) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d][k+d+1] is the furthest x on diagonal k after d edits
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		if done {
+			break
+		}
+	}
+
+	// Trace the path back, collecting the edits in reverse
+	var rev []op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, op{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			rev = append(rev, op{'+', b[y]})
+		} else {
+			x--
+			rev = append(rev, op{'-', a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		rev = append(rev, op{' ', a[x]})
+	}
+
+	ops := make([]op, len(rev))
+	for i, o := range rev {
+		ops[len(rev)-1-i] = o
+	}
+	return ops
+}
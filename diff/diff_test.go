@@ -0,0 +1,157 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "insert into empty",
+			old:  "",
+			new:  "a\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n",
+		},
+		{
+			name: "delete all",
+			old:  "a\nb\n",
+			new:  "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "missing newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "shared context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n",
+			new:  "one\n2\n3\n4\n5\n6\nseven\n",
+			want: "--- old\n+++ new\n@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(Unified("old", "new", []byte(test.old), []byte(test.new)))
+			if got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestUnifiedApply checks that applying the diff of random texts to the old
+// text gives the new one.
+func TestUnifiedApply(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	text := func() []byte {
+		var buf bytes.Buffer
+		for i := rnd.Intn(20); i > 0; i-- {
+			fmt.Fprintf(&buf, "%c\n", 'a'+rnd.Intn(4))
+		}
+		if buf.Len() > 0 && rnd.Intn(4) == 0 {
+			buf.Truncate(buf.Len() - 1)
+		}
+		return buf.Bytes()
+	}
+	for i := 0; i < 2000; i++ {
+		old, new := text(), text()
+		got, err := apply(old, Unified("old", "new", old, new))
+		if err != nil {
+			t.Fatalf("%q to %q: %v", old, new, err)
+		}
+		if !bytes.Equal(got, new) {
+			t.Fatalf("%q to %q: applying the diff gives %q", old, new, got)
+		}
+	}
+}
+
+// apply applies the unified diff d to old.
+func apply(old []byte, d []byte) ([]byte, error) {
+	if d == nil {
+		return old, nil
+	}
+	lines := splitLines(old)
+	var out []string
+	// Number of old lines copied so far
+	copied := 0
+	// Whether the last line of the diff was added to out, so that a missing
+	// newline marker applies to it
+	added := false
+	sc := bufio.NewScanner(bytes.NewReader(d))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+		case strings.HasPrefix(line, "@@"):
+			var oldStart, oldCount int
+			if _, err := fmt.Sscanf(line, "@@ -%d,%d", &oldStart, &oldCount); err != nil {
+				return nil, fmt.Errorf("bad hunk header %q", line)
+			}
+			if oldCount > 0 {
+				oldStart--
+			}
+			if oldStart < copied || oldStart > len(lines) {
+				return nil, fmt.Errorf("hunk %q out of order", line)
+			}
+			out = append(out, lines[copied:oldStart]...)
+			copied = oldStart
+			added = false
+		case line == `\ No newline at end of file`:
+			if added {
+				out[len(out)-1] = strings.TrimSuffix(out[len(out)-1], "\n")
+			}
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-"):
+			if copied >= len(lines) || strings.TrimSuffix(lines[copied], "\n") != line[1:] {
+				return nil, fmt.Errorf("line %d does not match %q", copied+1, line)
+			}
+			added = line[0] == ' '
+			if added {
+				out = append(out, line[1:]+"\n")
+			}
+			copied++
+		case strings.HasPrefix(line, "+"):
+			out = append(out, line[1:]+"\n")
+			added = true
+		default:
+			return nil, fmt.Errorf("bad line %q", line)
+		}
+	}
+	out = append(out, lines[copied:]...)
+	return []byte(strings.Join(out, "")), nil
+}
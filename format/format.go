@@ -0,0 +1,481 @@
+// Package format prints Kaleidoscope syntax trees as canonical source code.
+package format
+
+import (
+	"Kaleidoscope/lexer"
+	"Kaleidoscope/parser"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Source formats a whole source file, keeping its comments. The file name is
+// only used in errors. If the source does not parse the errors are returned.
+func Source(file string, src []byte) ([]byte, []error) {
+	lex := lexer.NewBytesLexer(file, src)
+	prog, errs := parser.NewParser(lex).ParseProgram()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	p := &printer{comments: lex.Comments(), blockStart: true}
+	p.program(prog)
+	if p.err != nil {
+		return nil, []error{p.err}
+	}
+	return p.buf.Bytes(), nil
+}
+
+// Node writes the canonical source of node, a *parser.Program or any node of
+// the syntax tree, to w. Top level items and statements are written with
+// their terminating ;.
+func Node(w io.Writer, node interface{}) error {
+	p := &printer{blockStart: true}
+	switch node := node.(type) {
+	case *parser.Program:
+		p.program(node)
+	case *parser.FunctionAST:
+		p.topLevel(node)
+	case *parser.PrototypeAST:
+		p.topLevel(node)
+	case *parser.StatementAST:
+		p.statement(node)
+	case parser.ExprAST:
+		p.expr(node, 0)
+	case parser.AST:
+		p.stmtBody(node)
+	default:
+		p.fail(node)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	buf bytes.Buffer
+	err error
+	// Comments not printed yet
+	comments []lexer.Comment
+	// Source line of the last thing printed, for keeping blank lines
+	line int
+	// Whether nothing has been printed yet in the current block
+	blockStart bool
+	// Current indentation
+	depth int
+}
+
+func (p *printer) program(prog *parser.Program) {
+	end := lexer.Pos{Offset: math.MaxInt}
+	for i, item := range prog.Items {
+		next := end
+		if i+1 < len(prog.Items) {
+			next = prog.Items[i+1].Span().Start
+		}
+		p.leadingComments(item.Span().Start)
+		p.startLine(item.Span().Start.Line)
+		p.topLevel(item)
+		p.endLine(item.Span().End, next)
+	}
+	p.leadingComments(end)
+}
+
+func (p *printer) topLevel(item parser.AST) {
+	switch item := item.(type) {
+	case *parser.PrototypeAST:
+		p.print("extern ")
+		p.prototype(item)
+		p.print(";")
+	case *parser.FunctionAST:
+		p.print("def ")
+		p.prototype(item.Prototype)
+		p.print(" ")
+		p.block(item.Body, item.End)
+	case *parser.AssignmentAST:
+		p.print("const ", item.VarName)
+		p.typeAnnotation(item.Type)
+		p.print(" = ")
+		p.expr(item.Expr, 0)
+		p.print(";")
+	default:
+		p.fail(item)
+	}
+}
+
+func (p *printer) prototype(proto *parser.PrototypeAST) {
+	p.print(proto.ReturnType.String(), " ", proto.FuncName, "(")
+	for i, param := range proto.Params {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.print(param.Type.String(), " ", param.Name)
+	}
+	p.print(")")
+}
+
+// block prints a { } block. Comments up to end, the end of the block, are
+// printed inside it.
+func (p *printer) block(stmts []*parser.StatementAST, end lexer.Pos) {
+	p.print("{\n")
+	p.depth++
+	p.blockStart = true
+	for i, stmt := range stmts {
+		next := end
+		if i+1 < len(stmts) {
+			next = stmts[i+1].Start
+		}
+		p.leadingComments(stmt.Start)
+		p.startLine(stmt.Start.Line)
+		p.statement(stmt)
+		p.endLine(stmt.End, next)
+	}
+	p.leadingComments(end)
+	p.depth--
+	p.print(strings.Repeat("\t", p.depth), "}")
+}
+
+func (p *printer) statement(stmt *parser.StatementAST) {
+	p.stmtBody(stmt.AST)
+	p.print(";")
+}
+
+func (p *printer) stmtBody(node parser.AST) {
+	switch node := node.(type) {
+	case *parser.AssignmentAST:
+		p.print("set ", node.VarName)
+		p.typeAnnotation(node.Type)
+		p.print(" ")
+		if node.Operator != nil {
+			p.print(node.Operator.Op)
+		}
+		p.print("= ")
+		p.expr(node.Expr, 0)
+	case *parser.VarDeclAST:
+		if node.Mutable {
+			p.print("var ")
+		} else {
+			p.print("let ")
+		}
+		p.print(node.VarName)
+		p.typeAnnotation(node.Type)
+		if node.Expr != nil {
+			p.print(" = ")
+			p.expr(node.Expr, 0)
+		}
+	case *parser.ReturnAST:
+		p.print("return ")
+		p.expr(node.Expr, 0)
+	case *parser.IfAST:
+		p.print("if ")
+		p.expr(node.Cond, 0)
+		p.print(" ")
+		p.block(node.IfBody, elseStart(node))
+		if node.ElseIf != nil {
+			p.print(" else ")
+			p.stmtBody(node.ElseIf)
+		} else if node.Else != nil || len(node.ElseBody) > 0 {
+			p.print(" else ")
+			p.block(node.ElseBody, node.End)
+		}
+	case *parser.WhileAST:
+		p.label(node.Label)
+		p.print("while ")
+		p.expr(node.Cond, 0)
+		p.print(" ")
+		p.block(node.Body, node.End)
+	case *parser.ForAST:
+		p.label(node.Label)
+		p.print("for ")
+		if node.Init != nil {
+			p.stmtBody(node.Init)
+		}
+		p.print(";")
+		if node.Cond != nil {
+			p.print(" ")
+			p.expr(node.Cond, 0)
+		}
+		p.print(";")
+		if node.Step != nil {
+			p.print(" ")
+			p.stmtBody(node.Step)
+		}
+		p.print(" ")
+		p.block(node.Body, node.End)
+	case *parser.ForRangeAST:
+		p.label(node.Label)
+		p.print("for ", node.VarName, " in ")
+		p.expr(node.From, 0)
+		p.print("..")
+		p.expr(node.To, 0)
+		p.print(" ")
+		p.block(node.Body, node.End)
+	case *parser.BreakAST:
+		p.print("break")
+		if node.Label != "" {
+			p.print(" ", node.Label)
+		}
+	case *parser.ContinueAST:
+		p.print("continue")
+		if node.Label != "" {
+			p.print(" ", node.Label)
+		}
+	case parser.ExprAST:
+		if startsWithIf(node) {
+			// Keep it from being parsed as an if statement
+			p.print("(")
+			p.expr(node, 0)
+			p.print(")")
+		} else {
+			p.expr(node, 0)
+		}
+	default:
+		p.fail(node)
+	}
+}
+
+// elseStart returns the position of the else keyword of an if statement, which
+// ends the comments that belong in its if body.
+func elseStart(node *parser.IfAST) lexer.Pos {
+	switch {
+	case node.Else != nil:
+		return *node.Else
+	case node.ElseIf != nil:
+		return node.ElseIf.Start
+	case len(node.ElseBody) > 0:
+		return node.ElseBody[0].Start
+	}
+	return node.End
+}
+
+// startsWithIf reports whether e is printed starting with an if expression.
+func startsWithIf(e parser.ExprAST) bool {
+	for {
+		switch x := e.(type) {
+		case *parser.IfExprAST:
+			return true
+		case *parser.BinaryExprAST:
+			e = x.Lhs
+		default:
+			return false
+		}
+	}
+}
+
+func (p *printer) typeAnnotation(typ parser.Type) {
+	if typ != parser.NoType {
+		p.print(": ", typ.String())
+	}
+}
+
+func (p *printer) label(label string) {
+	if label != "" {
+		p.print(label, ": ")
+	}
+}
+
+// powPrec is the precedence of **, which binds more tightly than a prefix
+// operator: -a ** 2 is -(a ** 2).
+var powPrec = parser.Operator{Op: "**"}.GetPrecedence()
+
+// expr prints e, in parentheses if it is a binary expression with a lower
+// precedence than prec.
+func (p *printer) expr(e parser.ExprAST, prec int) {
+	p.innerComments(e.Span().Start, false)
+	switch e := e.(type) {
+	case *parser.BinaryExprAST:
+		opPrec := e.Operator.GetPrecedence()
+		if opPrec < prec {
+			p.print("(")
+		}
+		// The operand on the side the operator does not associate to needs
+		// parentheses if it has the same precedence
+		lhsPrec, rhsPrec := opPrec, opPrec+1
+		if e.Operator.IsRightAssoc() {
+			lhsPrec, rhsPrec = opPrec+1, opPrec
+		}
+		p.expr(e.Lhs, lhsPrec)
+		p.print(" ", e.Operator.Op, " ")
+		p.expr(e.Rhs, rhsPrec)
+		if opPrec < prec {
+			p.print(")")
+		}
+	case *parser.UnaryExprAST:
+		// Only the left operand of ** needs parentheses around a unary
+		// expression
+		if prec > powPrec {
+			p.print("(")
+		}
+		p.print(e.Operator.Op)
+		if inner, ok := e.Operand.(*parser.UnaryExprAST); ok && lexer.IsMultiCharOp(e.Operator.Op+inner.Operator.Op) {
+			// Keep operators apart that would lex as one token, such as <<
+			p.print(" ")
+		}
+		p.expr(e.Operand, powPrec)
+		if prec > powPrec {
+			p.print(")")
+		}
+	case *parser.IfExprAST:
+		p.print("if ")
+		p.expr(e.Cond, 0)
+		p.print(" { ")
+		p.expr(e.Then, 0)
+		p.print(" } else ")
+		if _, ok := e.Else.(*parser.IfExprAST); ok {
+			p.expr(e.Else, 0)
+		} else {
+			p.print("{ ")
+			p.expr(e.Else, 0)
+			p.print(" }")
+		}
+	case *parser.CallExprAST:
+		p.print(e.FuncName, "(")
+		for i, arg := range e.Args {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.expr(arg, 0)
+		}
+		p.innerComments(e.End, true)
+		p.print(")")
+	case *parser.NumberExprAST:
+		if e.Raw != "" {
+			p.print(e.Raw)
+		} else {
+			p.print(formatNumber(e.Val))
+		}
+	case *parser.StringExprAST:
+		if e.Raw != "" {
+			p.print(e.Raw)
+		} else {
+			p.print(quote(e.Val))
+		}
+	case *parser.BoolExprAST:
+		p.print(strconv.FormatBool(e.Val))
+	case *parser.VariableExprAST:
+		p.print(e.Name)
+	default:
+		p.fail(e)
+	}
+}
+
+// startLine starts printing something that begins at the given source line,
+// keeping a single blank line before it if there was one in the source.
+func (p *printer) startLine(line int) {
+	if !p.blockStart && p.line > 0 && line > p.line+1 {
+		p.print("\n")
+	}
+	p.blockStart = false
+	p.print(strings.Repeat("\t", p.depth))
+}
+
+// endLine ends the line of something that ended at end, after any comments
+// that follow it on the same source line before next.
+func (p *printer) endLine(end lexer.Pos, next lexer.Pos) {
+	for len(p.comments) > 0 && end.Line > 0 &&
+		p.comments[0].Start.Line == end.Line && p.comments[0].Start.Offset < next.Offset {
+		p.print(" ", p.comments[0].Text)
+		end = p.comments[0].End
+		p.comments = p.comments[1:]
+	}
+	p.line = end.Line
+	p.print("\n")
+}
+
+// innerComments prints the comments before pos inside an expression, such as
+// between the arguments of a call. pos is the start of the next operand, or
+// the end of the expression if closing is set. A comment that ended its
+// source line still does, and the expression continues indented on the next
+// line.
+func (p *printer) innerComments(pos lexer.Pos, closing bool) {
+	for len(p.comments) > 0 && p.comments[0].Start.Offset < pos.Offset {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		if closing && !p.afterSpace() {
+			p.print(" ")
+		}
+		p.print(comment.Text)
+		if comment.End.Line < pos.Line {
+			p.print("\n", strings.Repeat("\t", p.depth+1))
+		} else if !closing {
+			p.print(" ")
+		}
+	}
+}
+
+// afterSpace reports whether the last thing printed was whitespace or an
+// opening parenthesis.
+func (p *printer) afterSpace() bool {
+	b := p.buf.Bytes()
+	return len(b) == 0 || strings.IndexByte(" \t\n(", b[len(b)-1]) >= 0
+}
+
+// leadingComments prints the comments before pos on lines of their own.
+func (p *printer) leadingComments(pos lexer.Pos) {
+	for len(p.comments) > 0 && p.comments[0].Start.Offset < pos.Offset {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		p.startLine(comment.Start.Line)
+		p.print(comment.Text)
+		p.line = comment.End.Line
+		p.print("\n")
+	}
+}
+
+func (p *printer) print(strs ...string) {
+	for _, s := range strs {
+		p.buf.WriteString(s)
+	}
+}
+
+func (p *printer) fail(node interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("format: unexpected node %T", node)
+	}
+}
+
+// formatNumber formats v so that it lexes back to the same value, without an
+// exponent for integers.
+func formatNumber(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e21 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// quote returns s as a string literal, escaping quotes, backslashes and
+// control characters.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for len(s) > 0 {
+		chr, size := utf8.DecodeRuneInString(s)
+		switch {
+		case chr == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, "\\x%02x", s[0])
+		case chr == '"' || chr == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(chr)
+		case chr == '\n':
+			b.WriteString("\\n")
+		case chr == '\t':
+			b.WriteString("\\t")
+		case chr == '\r':
+			b.WriteString("\\r")
+		case chr == 0:
+			b.WriteString("\\0")
+		case chr < 0x20 || chr == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", chr)
+		default:
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package format
+
+import (
+	"Kaleidoscope/lexer"
+	"Kaleidoscope/parser"
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestGolden formats each testdata/*.input file and compares the result with
+// the matching .golden file, which must itself be formatted already.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden tests found")
+	}
+	for _, file := range files {
+		golden := strings.TrimSuffix(file, ".input") + ".golden"
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, errs := Source(file, src)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if *update {
+				if err := os.WriteFile(golden, res, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res, want) {
+				t.Errorf("formatted %s differs from %s:\n%s", file, golden, res)
+			}
+
+			// Formatting the result again must not change it
+			again, errs := Source(golden, res)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if !bytes.Equal(again, res) {
+				t.Errorf("formatting %s is not idempotent:\n%s", golden, again)
+			}
+		})
+	}
+}
+
+// TestLiterals checks that literals without source text are printed so that
+// they lex back to the same value.
+func TestLiterals(t *testing.T) {
+	tests := []struct {
+		node parser.ExprAST
+		want string
+	}{
+		{&parser.StringExprAST{Val: "plain"}, `"plain"`},
+		{&parser.StringExprAST{Val: "a\"b\\c"}, `"a\"b\\c"`},
+		{&parser.StringExprAST{Val: "\n\t\r\x00\x01"}, `"\n\t\r\0\x01"`},
+		{&parser.StringExprAST{Val: "\xff\u00e9"}, `"\xffé"`},
+		{&parser.StringExprAST{Val: "x", Raw: "`x`"}, "`x`"},
+		{&parser.NumberExprAST{Val: 1}, "1"},
+		{&parser.NumberExprAST{Val: 0.1}, "0.1"},
+		{&parser.NumberExprAST{Val: 1e300}, "1e+300"},
+		{&parser.NumberExprAST{Val: 31, Raw: "0x1F"}, "0x1F"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Node(&buf, test.node); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+			continue
+		}
+
+		lex := lexer.NewStringLexer("", buf.String())
+		lex.NextToken()
+		switch node := test.node.(type) {
+		case *parser.StringExprAST:
+			if lex.CurrTok != lexer.TokStringConst || lex.String != node.Val {
+				t.Errorf("%s lexes to %q, want %q", buf.String(), lex.String, node.Val)
+			}
+		case *parser.NumberExprAST:
+			if lex.CurrTok != lexer.TokNumVal || lex.NumVal != node.Val {
+				t.Errorf("%s lexes to %v, want %v", buf.String(), lex.NumVal, node.Val)
+			}
+		}
+	}
+}
@@ -3,232 +3,756 @@ package lexer
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	CurrTok int
+	CurrTok TokenKind
 	String  string
 	NumVal  float64
-	reader  *bufio.Reader
-}
-
-const (
-	// Lexer Type Tokens
-	TokIdentifier  int = -1
-	TokNumVal      int = -2
-	TokStringConst int = -3
-
-	// Variable Type Tokens
-	TokString int = -4
-	TokDouble int = -5
-	TokVoid   int = -6
-
-	// Keyword Tokens
-	TokDef    int = -10
-	TokExtern int = -11
-	TokSet    int = -12
-	TokReturn int = -13
-	TokConst  int = -14
-	TokIf     int = -15
-	TokElse   int = -16
-	TokWhile  int = -27
-
-	TokEOF int = -99
-)
+	Token   Token
+	// Prev is the token before Token
+	Prev    Token
+	sources []Source
+	// Index into sources of the source being lexed
+	srcIdx    int
+	src       []byte
+	file      string
+	cur       cursor
+	prev      cursor
+	lookahead []Token
+	// Position and code of an error found inside the token being scanned
+	errPos  *cursor
+	errCode string
+	// Interned identifier and operator text
+	names map[string]string
+	// Comments skipped so far and the source position up to which they have
+	// been recorded
+	comments    []Comment
+	commentsEnd struct {
+		srcIdx int
+		offset int
+	}
+}
+
+// Source is a named piece of source code to be lexed.
+type Source struct {
+	File string
+	Src  []byte
+}
+
+// State is a snapshot of the lexer that can be restored to backtrack.
+type State struct {
+	token     Token
+	prevToken Token
+	srcIdx    int
+	cur       cursor
+	prev      cursor
+	lookahead []Token
+}
+
+// cursor is a position in the current source. It is kept separate from Pos
+// so that advancing it does not copy the file name.
+type cursor struct {
+	offset int
+	line   int
+	column int
+}
+
+// position returns the Pos of c in the current source.
+func (l *Lexer) position(c cursor) Pos {
+	return Pos{File: l.file, Offset: c.offset, Line: c.line, Column: c.column}
+}
+
+// posError is a lexing error that starts before the token being scanned.
+type posError struct {
+	pos  Pos
+	code string
+	msg  string
+}
+
+func (e *posError) Error() string {
+	return e.msg
+}
+
+var errUnterminatedString = errors.New("unterminated string literal")
 
 func NewLexer(reader *bufio.Reader) *Lexer {
+	return NewFileLexer("", reader)
+}
+
+// NewFileLexer creates a lexer whose token positions refer to the given file
+// name. The reader is read to the end up front.
+func NewFileLexer(file string, reader *bufio.Reader) *Lexer {
+	src, err := io.ReadAll(reader)
+	l := NewBytesLexer(file, src)
+	if err != nil {
+		l.lookahead = append(l.lookahead, Token{
+			Kind:  TokError,
+			Code:  "E0109",
+			Text:  "could not read " + file + ": " + err.Error(),
+			Start: l.position(l.cur),
+			End:   l.position(l.cur),
+		})
+	}
+	return l
+}
+
+// NewStringLexer creates a lexer over src.
+func NewStringLexer(file string, src string) *Lexer {
+	return NewBytesLexer(file, []byte(src))
+}
+
+// NewBytesLexer creates a lexer over src. The slice must not be modified while
+// the lexer is in use.
+func NewBytesLexer(file string, src []byte) *Lexer {
+	return NewMultiLexer(Source{File: file, Src: src})
+}
+
+// NewMultiLexer creates a lexer that produces the tokens of each source in
+// turn, followed by a single TokEOF.
+func NewMultiLexer(sources ...Source) *Lexer {
 	l := Lexer{
 		CurrTok: 0,
 		String:  "",
 		NumVal:  0,
-		reader:  reader,
+		sources: sources,
+		names:   map[string]string{},
 	}
+	l.startSource(0)
 
 	return &l
 }
 
+// Sources returns the sources being lexed.
+func (l *Lexer) Sources() []Source {
+	return l.sources
+}
+
+// Comments returns the comments skipped so far, in source order.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+// startSource positions the lexer at the beginning of sources[idx].
+func (l *Lexer) startSource(idx int) {
+	l.srcIdx = idx
+	l.src = nil
+	file := ""
+	if idx < len(l.sources) {
+		l.src = l.sources[idx].Src
+		file = l.sources[idx].File
+	}
+	l.file = file
+	l.cur = cursor{offset: 0, line: 1, column: 1}
+	l.prev = l.cur
+}
+
 func (l *Lexer) NextToken() {
-	l.CurrTok = l.parseToken()
+	if len(l.lookahead) > 0 {
+		l.setToken(l.lookahead[0])
+		l.lookahead = l.lookahead[1:]
+		return
+	}
+	l.setToken(l.parseToken())
 }
 
-func (l *Lexer) parseToken() int {
-	chr, err := l.reader.ReadByte()
-	if err != nil {
-		return TokEOF
+func (l *Lexer) setToken(tok Token) {
+	l.Prev = l.Token
+	l.Token = tok
+	l.CurrTok = tok.Kind
+	l.String = tok.Text
+	l.NumVal = tok.NumVal
+}
+
+// Peek returns the nth token after the current one without consuming it.
+// Peek(1) is the token the next call to NextToken will produce.
+func (l *Lexer) Peek(n int) Token {
+	for len(l.lookahead) < n {
+		if len(l.lookahead) > 0 && l.lookahead[len(l.lookahead)-1].Kind == TokEOF {
+			return l.lookahead[len(l.lookahead)-1]
+		}
+		l.lookahead = append(l.lookahead, l.parseToken())
+	}
+	if n < 1 {
+		return l.Token
 	}
+	return l.lookahead[n-1]
+}
 
-	chr, err = l.skipCommentsAndWhitespace(chr, err)
-	if err != nil {
-		return TokEOF
+// Save returns the current state of the lexer.
+func (l *Lexer) Save() State {
+	return State{
+		token:     l.Token,
+		prevToken: l.Prev,
+		srcIdx:    l.srcIdx,
+		cur:       l.cur,
+		prev:      l.prev,
+		lookahead: append([]Token(nil), l.lookahead...),
+	}
+}
+
+// Restore returns the lexer to a state previously returned by Save.
+func (l *Lexer) Restore(state State) {
+	l.startSource(state.srcIdx)
+	l.cur = state.cur
+	l.prev = state.prev
+	l.lookahead = append([]Token(nil), state.lookahead...)
+	l.setToken(state.token)
+	l.Prev = state.prevToken
+}
+
+// Tokens returns all tokens after the current one, up to but not including
+// TokEOF. The lexer itself is left where it was.
+func (l *Lexer) Tokens() []Token {
+	state := l.Save()
+	defer l.Restore(state)
+
+	var toks []Token
+	for {
+		l.NextToken()
+		if l.CurrTok == TokEOF {
+			return toks
+		}
+		toks = append(toks, l.Token)
+	}
+}
+
+// readByte reads the next byte and advances the current position past it.
+func (l *Lexer) readByte() (byte, error) {
+	if l.cur.offset >= len(l.src) {
+		return 0, io.EOF
+	}
+	chr := l.src[l.cur.offset]
+
+	l.prev = l.cur
+	l.cur.offset++
+	if chr == '\n' {
+		l.cur.line++
+		l.cur.column = 1
+	} else if utf8.RuneStart(chr) {
+		// Columns count characters, not bytes
+		l.cur.column++
+	}
+	return chr, nil
+}
+
+func (l *Lexer) parseToken() Token {
+	chr, err := l.readByte()
+	if err == nil {
+		chr, err = l.skipCommentsAndWhitespace(chr, err)
+	}
+
+	if posErr, ok := err.(*posError); ok {
+		return Token{Kind: TokError, Text: posErr.msg, Code: posErr.code, Start: posErr.pos, End: l.position(l.cur)}
+	} else if err != nil {
+		// Continue with the next source, if any
+		if l.srcIdx+1 < len(l.sources) {
+			l.startSource(l.srcIdx + 1)
+			return l.parseToken()
+		}
+		return l.eofToken()
+	}
+
+	tok := Token{Start: l.position(l.prev)}
+	tok.Kind, tok.Text, tok.NumVal = l.scanToken(chr)
+	tok.End = l.position(l.cur)
+
+	if tok.Kind == TokError {
+		tok.Code = l.errCode
+	}
+	if tok.Kind == TokStringConst {
+		tok.Raw = string(l.src[tok.Start.Offset:tok.End.Offset])
+	}
+	if l.errPos != nil {
+		// Point errors at the offending character rather than the whole token
+		if tok.Kind == TokError {
+			tok.Start = l.position(*l.errPos)
+		}
+		l.errPos = nil
+	}
+	return tok
+}
+
+// fail returns an error token with the given code and message.
+func (l *Lexer) fail(code string, msg string) (TokenKind, string, float64) {
+	l.errCode = code
+	return TokError, msg, 0
+}
+
+func (l *Lexer) eofToken() Token {
+	return Token{Kind: TokEOF, Start: l.position(l.cur), End: l.position(l.cur)}
+}
+
+func (l *Lexer) scanToken(chr byte) (TokenKind, string, float64) {
+	start := l.prev.offset
+	r := rune(chr)
+	if chr >= utf8.RuneSelf {
+		var ok bool
+		if r, _, ok = l.readRuneRest(); !ok {
+			return l.fail("E0107", "invalid UTF-8 encoding")
+		}
 	}
 
 	// identifier/keyword token
-	if l.validFirstIdentChar(chr) {
-		str := string(chr)
-
-		peek, _ := l.reader.Peek(1)
-		for l.validIdentChar(peek[0]) {
-			chr, _ = l.reader.ReadByte()
-			str += string(chr)
-			peek, _ = l.reader.Peek(1)
-		}
-
-		if str == "def" {
-			return TokDef
-		} else if str == "extern" {
-			return TokExtern
-		} else if str == "set" {
-			return TokSet
-		} else if str == "const" {
-			return TokConst
-		} else if str == "return" {
-			return TokReturn
-		} else if str == "if" {
-			return TokIf
-		} else if str == "else" {
-			return TokElse
-		} else if str == "while" {
-			return TokWhile
-		} else if str == "string" {
-			return TokString
-		} else if str == "double" {
-			return TokDouble
-		} else if str == "void" {
-			return TokVoid
-		}
-
-		l.String = str
-		return TokIdentifier
+	if l.validFirstIdentChar(r) {
+		l.skipIdentChars()
+		str := l.intern(l.src[start:l.cur.offset])
+
+		if tok, ok := keywords[str]; ok {
+			return tok, str, 0
+		}
+
+		return TokIdentifier, str, 0
 
 	}
 
+	if chr >= utf8.RuneSelf {
+		return l.fail("E0108", fmt.Sprintf("unexpected character %q", r))
+	}
+
 	// Number token
-	if unicode.IsDigit(rune(chr)) {
-		numStr := string(chr)
-
-		peek, _ := l.reader.Peek(1)
-		for unicode.IsDigit(rune(peek[0])) {
-			chr, err = l.reader.ReadByte()
-			if err != nil {
-				return TokEOF
-			}
-			numStr += string(chr)
-			peek, _ = l.reader.Peek(1)
+	if isDigit(chr) {
+		return l.scanNumber(chr)
+	}
+	if next, ok := l.peekByte(); ok && chr == '.' && isDigit(next) {
+		return l.scanNumber(chr)
+	}
+
+	// String constant token
+	if chr == '"' {
+		return l.scanString()
+	}
+
+	// Raw string constant token
+	if chr == '`' {
+		return l.scanRawString()
+	}
+	// Multi-character operator tokens. All of them end in one of these
+	// characters
+	if next, ok := l.peekByte(); ok && strings.IndexByte("=&|<>*.", next) >= 0 {
+		if tok, ok := multiCharOps[string(l.src[start:start+2])]; ok {
+			// Eat second character
+			_, _ = l.readByte()
+			return tok, l.intern(l.src[start : start+2]), 0
+		}
+	}
+
+	// Return other tokens as they are
+	return TokenKind(chr), string(l.src[start : start+1]), 0
+}
+
+// scanNumber scans the rest of a number literal starting with chr. Decimal
+// literals may have a fraction and exponent, integer literals may use a 0x,
+// 0b or 0o prefix, and digits may be separated by underscores.
+func (l *Lexer) scanNumber(chr byte) (TokenKind, string, float64) {
+	start := l.prev.offset
+	prefixed := false
+	if next, ok := l.peekByte(); ok && chr == '0' && strings.IndexByte("xXbBoO", next) >= 0 {
+		prefixed = true
+	}
+
+	// Consume the longest run that could belong to the literal so that
+	// malformed literals are reported as a whole
+	for {
+		next, ok := l.peekByte()
+		if !ok {
+			break
 		}
 
-		peek, _ = l.reader.Peek(1)
-		if peek[0] == '.' {
-			chr, err = l.reader.ReadByte()
-			if err != nil {
-				return TokEOF
+		if next == '.' {
+			// Leave .. for range expressions
+			if after, _ := l.peekByteAt(1); after == '.' {
+				break
 			}
-			numStr += "."
-
-			peek, _ = l.reader.Peek(1)
-			for unicode.IsDigit(rune(peek[0])) {
-				chr, err = l.reader.ReadByte()
-				if err != nil {
-					return TokEOF
-				}
-				numStr += string(chr)
-				peek, _ = l.reader.Peek(1)
+		} else if next == '+' || next == '-' {
+			last := l.src[l.cur.offset-1]
+			if prefixed || (last != 'e' && last != 'E') {
+				break
 			}
+		} else if !isDigit(next) && !isASCIILetter(next) && next != '_' {
+			break
 		}
 
-		l.NumVal, _ = strconv.ParseFloat(numStr, 64)
-		return TokNumVal
+		_, _ = l.readByte()
 	}
 
-	// String constant token
-	if chr == '"' {
-		// Eat "
-		str := ""
-
-		peek, _ := l.reader.Peek(1)
-		for peek[0] != '"' {
-			chr, err = l.reader.ReadByte()
-			if err != nil {
-				return TokEOF
+	numStr := string(l.src[start:l.cur.offset])
+	var numVal float64
+	var err error
+	if prefixed {
+		var intVal uint64
+		intVal, err = strconv.ParseUint(numStr, 0, 64)
+		numVal = float64(intVal)
+	} else {
+		numVal, err = strconv.ParseFloat(numStr, 64)
+	}
+
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		return l.fail("E0105", "number literal out of range: "+numStr)
+	} else if err != nil {
+		return l.fail("E0104", "malformed number literal: "+numStr)
+	}
+
+	return TokNumVal, numStr, numVal
+}
+
+// peekByte returns the next byte without consuming it.
+func (l *Lexer) peekByte() (byte, bool) {
+	return l.peekByteAt(0)
+}
+
+// peekByteAt returns the byte n bytes after the next one without consuming it.
+func (l *Lexer) peekByteAt(n int) (byte, bool) {
+	if l.cur.offset+n >= len(l.src) {
+		return 0, false
+	}
+	return l.src[l.cur.offset+n], true
+}
+
+// scanString scans the rest of a double quoted string literal, processing
+// escape sequences. The opening " has already been read.
+func (l *Lexer) scanString() (TokenKind, string, float64) {
+	start := l.cur.offset
+	// The decoded contents are only built up once an escape sequence is found,
+	// until then they are a slice of the source
+	var str []byte
+	escaped := false
+	var escErr error
+
+	for {
+		chr, err := l.readByte()
+		if err != nil || chr == '\n' {
+			return l.fail("E0101", errUnterminatedString.Error())
+		}
+
+		if chr == '"' {
+			break
+		}
+
+		if chr != '\\' {
+			char := l.readStringChar(chr)
+			if escaped {
+				str = append(str, char...)
 			}
-			str += string(chr)
-			peek, _ = l.reader.Peek(1)
+			continue
 		}
 
-		// Eat "
-		_, _ = l.reader.ReadByte()
+		if !escaped {
+			escaped = true
+			str = append(str, l.src[start:l.prev.offset]...)
+		}
+		str, err = l.scanEscape(str)
+		if err == errUnterminatedString {
+			return l.fail("E0101", err.Error())
+		}
+		// Keep scanning to the closing " so lexing resumes after the literal
+		if err != nil && escErr == nil {
+			escErr = err
+		}
+	}
 
-		l.String = str
-		return TokStringConst
+	if escErr != nil {
+		return l.fail("E0102", escErr.Error())
 	}
-	// Return other tokens as they are
-	return int(chr)
+	if l.errPos != nil {
+		return l.fail("E0107", "invalid UTF-8 encoding in string literal")
+	}
+	if !escaped {
+		// Exclude the closing "
+		return TokStringConst, string(l.src[start : l.cur.offset-1]), 0
+	}
+	return TokStringConst, string(str), 0
 }
 
-func (l *Lexer) validIdentChar(chr byte) bool {
-	return unicode.IsLetter(rune(chr)) || unicode.IsDigit(rune(chr)) || chr == '_'
+// scanEscape decodes the escape sequence following a \ and appends it to str.
+func (l *Lexer) scanEscape(str []byte) ([]byte, error) {
+	chr, err := l.readByte()
+	if err != nil || chr == '\n' {
+		return str, errUnterminatedString
+	}
+
+	switch chr {
+	case 'n':
+		return append(str, '\n'), nil
+	case 't':
+		return append(str, '\t'), nil
+	case 'r':
+		return append(str, '\r'), nil
+	case '0':
+		return append(str, 0), nil
+	case '"', '\\', '\'':
+		return append(str, chr), nil
+	case 'x':
+		digits := l.scanHexDigits(2)
+		if len(digits) != 2 {
+			return str, errors.New("invalid escape sequence \\x" + digits + ": expected two hex digits")
+		}
+		val, _ := strconv.ParseUint(digits, 16, 8)
+		return append(str, byte(val)), nil
+	case 'u':
+		if next, ok := l.peekByte(); !ok || next != '{' {
+			return str, errors.New("invalid escape sequence \\u: expected {")
+		}
+		// Eat {
+		_, _ = l.readByte()
+
+		digits := l.scanHexDigits(6)
+		if next, ok := l.peekByte(); !ok || next != '}' || len(digits) == 0 {
+			return str, errors.New("invalid escape sequence \\u{" + digits + ": expected 1 to 6 hex digits and }")
+		}
+		// Eat }
+		_, _ = l.readByte()
+
+		val, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(val)) {
+			return str, errors.New("invalid escape sequence \\u{" + digits + "}: not a valid unicode code point")
+		}
+		return utf8.AppendRune(str, rune(val)), nil
+	}
+	return str, errors.New("invalid escape sequence \\" + string(chr))
 }
 
-func (l *Lexer) validFirstIdentChar(chr byte) bool {
-	return unicode.IsLetter(rune(chr))
+// scanHexDigits consumes up to max hex digits.
+func (l *Lexer) scanHexDigits(max int) string {
+	start := l.cur.offset
+	for l.cur.offset-start < max {
+		next, ok := l.peekByte()
+		if !ok || !isHexDigit(next) {
+			break
+		}
+		_, _ = l.readByte()
+	}
+	return string(l.src[start:l.cur.offset])
 }
 
-func (l *Lexer) skipCommentsAndWhitespace(chr byte, err error) (byte, error) {
-	chr, err = l.skipWhitespace(chr, err)
-	if err != nil {
-		return 0, err
+// scanRawString scans the rest of a backtick quoted string literal. Raw
+// strings may span multiple lines and do not process escape sequences.
+func (l *Lexer) scanRawString() (TokenKind, string, float64) {
+	start := l.cur.offset
+	hasCR := false
+	for {
+		chr, err := l.readByte()
+		if err != nil {
+			return l.fail("E0103", "unterminated raw string literal")
+		}
+		if chr == '`' {
+			break
+		}
+		hasCR = hasCR || chr == '\r'
+		l.readStringChar(chr)
 	}
 
-	// Ignore comments
-	peek, _ := l.reader.Peek(1)
-	if len(peek) < 1 {
-		return 0, nil
+	if l.errPos != nil {
+		return l.fail("E0107", "invalid UTF-8 encoding in string literal")
+	}
+	// Exclude the closing `
+	str := string(l.src[start : l.cur.offset-1])
+	if hasCR {
+		// Normalise CRLF line endings
+		str = strings.ReplaceAll(str, "\r", "")
 	}
-	if chr == '/' && peek[0] == '*' {
-		// Eat *
-		_, err = l.reader.ReadByte()
+	return TokStringConst, str, 0
+}
+
+func isHexDigit(chr byte) bool {
+	return ('0' <= chr && chr <= '9') || ('a' <= chr && chr <= 'f') || ('A' <= chr && chr <= 'F')
+}
+
+func isDigit(chr byte) bool {
+	return '0' <= chr && chr <= '9'
+}
+
+func isASCIILetter(chr byte) bool {
+	return ('a' <= chr && chr <= 'z') || ('A' <= chr && chr <= 'Z')
+}
+
+// validIdentChar reports whether r may continue an identifier, following the
+// Unicode XID_Continue property.
+func (l *Lexer) validIdentChar(r rune) bool {
+	return l.validFirstIdentChar(r) ||
+		unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue)
+}
+
+// validFirstIdentChar reports whether r may start an identifier, following
+// the Unicode XID_Start property.
+func (l *Lexer) validFirstIdentChar(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isASCIILetter(byte(r))
+	}
+	return unicode.IsLetter(r) || unicode.In(r, unicode.Nl, unicode.Other_ID_Start)
+}
+
+// skipIdentChars consumes the characters that continue an identifier.
+func (l *Lexer) skipIdentChars() {
+	for l.cur.offset < len(l.src) {
+		chr := l.src[l.cur.offset]
+		if chr < utf8.RuneSelf {
+			// ASCII fast path
+			if !isASCIILetter(chr) && !isDigit(chr) && chr != '_' {
+				return
+			}
+			_, _ = l.readByte()
+			continue
+		}
+
+		r, size := utf8.DecodeRune(l.src[l.cur.offset:])
+		if r == utf8.RuneError || !l.validIdentChar(r) {
+			return
+		}
+		for i := 0; i < size; i++ {
+			_, _ = l.readByte()
+		}
+	}
+}
+
+// intern returns a string equal to b, reusing the string from earlier calls
+// so repeated identifiers only allocate once.
+func (l *Lexer) intern(b []byte) string {
+	if str, ok := l.names[string(b)]; ok {
+		return str
+	}
+	str := string(b)
+	l.names[str] = str
+	return str
+}
+
+// readRuneRest consumes the remaining bytes of the multi-byte character whose
+// first byte was just read. ok is false if the bytes are not valid UTF-8, in
+// which case only the first byte has been consumed.
+func (l *Lexer) readRuneRest() (r rune, size int, ok bool) {
+	r, size = utf8.DecodeRune(l.src[l.prev.offset:])
+	if r == utf8.RuneError && size <= 1 {
+		return r, 1, false
+	}
+	for i := 1; i < size; i++ {
+		_, _ = l.readByte()
+	}
+	return r, size, true
+}
+
+// readStringChar consumes the character whose first byte chr was just read
+// and returns its bytes, recording the first invalid UTF-8 sequence found in
+// the literal.
+func (l *Lexer) readStringChar(chr byte) []byte {
+	start := l.prev.offset
+	if chr < utf8.RuneSelf {
+		return l.src[start : start+1]
+	}
+
+	_, size, ok := l.readRuneRest()
+	if !ok && l.errPos == nil {
+		errPos := l.prev
+		l.errPos = &errPos
+	}
+	return l.src[start : start+size]
+}
+
+// skipCommentsAndWhitespace skips any run of whitespace, line comments
+// (// and #) and nested block comments, returning the first byte after them.
+func (l *Lexer) skipCommentsAndWhitespace(chr byte, err error) (byte, error) {
+	for {
+		chr, err = l.skipWhitespace(chr, err)
 		if err != nil {
 			return 0, err
 		}
 
-		peek, _ := l.reader.Peek(2)
-		if len(peek) < 2 {
-			return 0, errors.New("")
-		}
-		for peek[0] != '*' || peek[1] != '/' {
-			_, err = l.reader.ReadByte()
-			if err != nil {
-				return 0, err
+		start := l.prev
+		next, _ := l.peekByte()
+		if chr == '#' || (chr == '/' && next == '/') {
+			err = l.skipLineComment()
+			if err == nil {
+				// Leave out the newline
+				l.addComment(start, l.prev)
+			} else {
+				l.addComment(start, l.cur)
 			}
-			peek, _ = l.reader.Peek(2)
-			if len(peek) < 2 {
-				return 0, errors.New("")
+		} else if chr == '/' && next == '*' {
+			err = l.skipBlockComment()
+			if err == nil {
+				l.addComment(start, l.cur)
 			}
+		} else {
+			return chr, nil
 		}
-
-		// Eat */
-		_, _ = l.reader.ReadByte()
-		_, _ = l.reader.ReadByte()
-
-		chr, err = l.reader.ReadByte()
 		if err != nil {
 			return 0, err
 		}
 
-		chr, err = l.skipWhitespace(chr, err)
+		chr, err = l.readByte()
 		if err != nil {
 			return 0, err
 		}
+	}
+}
 
+// addComment records the comment between start and end. Comments that are
+// scanned again after Restore are only recorded once.
+func (l *Lexer) addComment(start cursor, end cursor) {
+	if l.srcIdx < l.commentsEnd.srcIdx ||
+		(l.srcIdx == l.commentsEnd.srcIdx && start.offset < l.commentsEnd.offset) {
+		return
 	}
-	return chr, nil
+	l.commentsEnd.srcIdx = l.srcIdx
+	l.commentsEnd.offset = end.offset
+
+	l.comments = append(l.comments, Comment{
+		Text:  strings.TrimRight(string(l.src[start.offset:end.offset]), "\r"),
+		Start: l.position(start),
+		End:   l.position(end),
+	})
+}
+
+// skipLineComment skips to the end of the current line.
+func (l *Lexer) skipLineComment() error {
+	for {
+		chr, err := l.readByte()
+		if err != nil || chr == '\n' {
+			return err
+		}
+	}
+}
+
+// skipBlockComment skips a possibly nested block comment whose opening / has
+// already been read.
+func (l *Lexer) skipBlockComment() error {
+	start := l.position(l.prev)
+
+	// Eat *
+	_, _ = l.readByte()
+
+	depth := 1
+	for depth > 0 {
+		chr, err := l.readByte()
+		if err != nil {
+			return &posError{
+				pos:  start,
+				code: "E0106",
+				msg:  fmt.Sprintf("unterminated comment starting at line %d", start.Line),
+			}
+		}
+
+		next, _ := l.peekByte()
+		if chr == '/' && next == '*' {
+			_, _ = l.readByte()
+			depth++
+		} else if chr == '*' && next == '/' {
+			_, _ = l.readByte()
+			depth--
+		}
+	}
+	return nil
 }
 
 func (l *Lexer) skipWhitespace(chr byte, err error) (byte, error) {
 	// Skip whitespace
-	for unicode.IsSpace(rune(chr)) {
-		chr, err = l.reader.ReadByte()
+	for chr == ' ' || chr == '\t' || chr == '\n' || chr == '\r' || chr == '\v' || chr == '\f' {
+		chr, err = l.readByte()
 		if err != nil {
 			return 0, err
 		}
@@ -0,0 +1,64 @@
+package lexer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// genProgram generates at least size bytes of Kaleidoscope source with a mix
+// of comments, identifiers, numbers, strings and operators.
+func genProgram(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, `/* function %d */
+def double func_%d(double x, int n) {
+	var acc_%d = x * 1.5e3 + 0.25;
+	let mask = n & 0x1F | 0b1010 << 2;
+	if acc_%d >= 10 && mask != 0 {
+		printf("value is %%f\n", acc_%d);
+	};
+	for i in 0..n {
+		set acc_%d += x ** 2; // grow
+	};
+	return acc_%d;
+}
+`, i, i%500, i, i, i, i, i)
+	}
+	return buf.Bytes()
+}
+
+// genLongLiterals generates at least size bytes of source made of long
+// identifiers and string literals.
+func genLongLiterals(size int) []byte {
+	ident := strings.Repeat("identifier_", 100)
+	str := strings.Repeat("string literal \\t with escapes \\u{1F600} ", 50)
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "let %s%d = \"%s\";\n", ident, i, str)
+	}
+	return buf.Bytes()
+}
+
+func benchmarkLexer(b *testing.B, src []byte) {
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := NewBytesLexer("bench.ks", src)
+		for l.NextToken(); l.CurrTok != TokEOF; l.NextToken() {
+			if l.CurrTok == TokError {
+				b.Fatalf("%s: %s", l.Token.Start, l.String)
+			}
+		}
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	benchmarkLexer(b, genProgram(4<<20))
+}
+
+func BenchmarkLexerLongLiterals(b *testing.B) {
+	benchmarkLexer(b, genLongLiterals(4<<20))
+}
@@ -0,0 +1,51 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addExamples adds the example programs to the seed corpus of f.
+func addExamples(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.ks"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(files) == 0 {
+		f.Fatal("no example programs found")
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+}
+
+// FuzzLexer checks that lexing any input terminates without panicking and
+// that every token lies within the source.
+func FuzzLexer(f *testing.F) {
+	addExamples(f)
+	f.Add([]byte("\"unterminated"))
+	f.Add([]byte("`raw\n/* /* nested */"))
+	f.Add([]byte("0x 1.2.3 1__0 0b102 1e+ \"\\u{110000}\" \xff"))
+	f.Add([]byte("a.. 1..2 ** <<= && || !"))
+	f.Fuzz(func(t *testing.T, src []byte) {
+		l := NewBytesLexer("fuzz.ks", src)
+		// Every token but EOF consumes at least one byte
+		for i := 0; i <= len(src); i++ {
+			l.NextToken()
+			if l.CurrTok == TokEOF {
+				return
+			}
+			tok := l.Token
+			if tok.Start.Offset < 0 || tok.End.Offset > len(src) || tok.Start.Offset > tok.End.Offset {
+				t.Fatalf("token %v has span %d..%d outside of the %d byte source",
+					tok.Kind, tok.Start.Offset, tok.End.Offset, len(src))
+			}
+		}
+		t.Fatalf("more than %d tokens in %d bytes", len(src), len(src))
+	})
+}
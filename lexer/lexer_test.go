@@ -0,0 +1,133 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeToken returns the kind, text and span of tok as line:column
+// positions, with the byte offsets of the span.
+func describeToken(tok Token) string {
+	return fmt.Sprintf("%v %q %d:%d-%d:%d @%d-%d", tok.Kind, tok.Text,
+		tok.Start.Line, tok.Start.Column, tok.End.Line, tok.End.Column, tok.Start.Offset, tok.End.Offset)
+}
+
+// allTokens returns the tokens of l up to and including the TokEOF.
+func allTokens(l *Lexer) []Token {
+	var toks []Token
+	for l.NextToken(); l.CurrTok != TokEOF; l.NextToken() {
+		toks = append(toks, l.Token)
+	}
+	return append(toks, l.Token)
+}
+
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "lines",
+			src:  "def f(x)\n  x+1;",
+			want: []string{
+				`def "def" 1:1-1:4 @0-3`,
+				`identifier "f" 1:5-1:6 @4-5`,
+				`( "(" 1:6-1:7 @5-6`,
+				`identifier "x" 1:7-1:8 @6-7`,
+				`) ")" 1:8-1:9 @7-8`,
+				`identifier "x" 2:3-2:4 @11-12`,
+				`+ "+" 2:4-2:5 @12-13`,
+				`number "1" 2:5-2:6 @13-14`,
+				`; ";" 2:6-2:7 @14-15`,
+				`end of file "" 2:7-2:7 @15-15`,
+			},
+		},
+		{
+			name: "unicode columns",
+			src:  "é = \"a\\tb\";\n\tλx",
+			want: []string{
+				`identifier "é" 1:1-1:2 @0-2`,
+				`= "=" 1:3-1:4 @3-4`,
+				`string literal "a\tb" 1:5-1:11 @5-11`,
+				`; ";" 1:11-1:12 @11-12`,
+				`identifier "λx" 2:2-2:4 @14-17`,
+				`end of file "" 2:4-2:4 @17-17`,
+			},
+		},
+		{
+			name: "comments and raw strings",
+			src:  "/* c\n */ a // d\n`r\ns` b",
+			want: []string{
+				`identifier "a" 2:5-2:6 @9-10`,
+				`string literal "r\ns" 3:1-4:3 @16-21`,
+				`identifier "b" 4:4-4:5 @22-23`,
+				`end of file "" 4:5-4:5 @23-23`,
+			},
+		},
+		{
+			name: "operators",
+			src:  "a<=b**2..c",
+			want: []string{
+				`identifier "a" 1:1-1:2 @0-1`,
+				`<= "<=" 1:2-1:4 @1-3`,
+				`identifier "b" 1:4-1:5 @3-4`,
+				`** "**" 1:5-1:7 @4-6`,
+				`number "2" 1:7-1:8 @6-7`,
+				`.. ".." 1:8-1:10 @7-9`,
+				`identifier "c" 1:10-1:11 @9-10`,
+				`end of file "" 1:11-1:11 @10-10`,
+			},
+		},
+		{
+			name: "errors",
+			src:  "x \"\\q\" y\n\n  1.5e3 0x 2",
+			want: []string{
+				`identifier "x" 1:1-1:2 @0-1`,
+				`error "invalid escape sequence \\q" 1:3-1:7 @2-6`,
+				`identifier "y" 1:8-1:9 @7-8`,
+				`number "1.5e3" 3:3-3:8 @12-17`,
+				`error "malformed number literal: 0x" 3:9-3:11 @18-20`,
+				`number "2" 3:12-3:13 @21-22`,
+				`end of file "" 3:13-3:13 @22-22`,
+			},
+		},
+		{
+			name: "invalid UTF-8",
+			src:  "a \xff b",
+			want: []string{
+				`identifier "a" 1:1-1:2 @0-1`,
+				`error "invalid UTF-8 encoding" 1:3-1:4 @2-3`,
+				`identifier "b" 1:5-1:6 @4-5`,
+				`end of file "" 1:6-1:6 @5-5`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, tok := range allTokens(NewStringLexer("test.ks", test.src)) {
+				got = append(got, describeToken(tok))
+				if tok.Start.File != "test.ks" || tok.End.File != "test.ks" {
+					t.Errorf("token %s is in file %q", describeToken(tok), tok.Start.File)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got tokens:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestMultiSourcePositions(t *testing.T) {
+	l := NewMultiLexer(Source{File: "a.ks", Src: []byte("x\ny")}, Source{File: "b.ks", Src: []byte(" z")})
+	want := []string{"a.ks:1:1 x", "a.ks:2:1 y", "b.ks:1:2 z", "b.ks:1:3 "}
+	var got []string
+	for _, tok := range allTokens(l) {
+		got = append(got, tok.Start.String()+" "+tok.Text)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package lexer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Pos is a location in the source being lexed. Line and Column start at 1.
+type Pos struct {
+	File   string `json:"file"`
+	Offset int    `json:"offset"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Comment is a comment skipped by the lexer. Text includes the comment
+// markers but not the newline ending a line comment.
+type Comment struct {
+	Text  string `json:"text"`
+	Start Pos    `json:"start"`
+	End   Pos    `json:"end"`
+}
+
+// Token is a single lexed token. Start is the position of its first byte and
+// End the position just past its last byte.
+type Token struct {
+	Kind   TokenKind `json:"kind"`
+	Text   string    `json:"text"`
+	NumVal float64   `json:"num_val,omitempty"`
+	Start  Pos       `json:"start"`
+	End    Pos       `json:"end"`
+	// Code identifies the error for TokError tokens
+	Code string `json:"code,omitempty"`
+	// Raw is the source text of a string constant, with its quotes and
+	// escapes
+	Raw string `json:"raw,omitempty"`
+}
+
+// TokenKind identifies the type of a token. Single character tokens use the
+// character itself as their kind.
+type TokenKind int
+
+const (
+	// Lexer Type Tokens
+	TokIdentifier  TokenKind = -1
+	TokNumVal      TokenKind = -2
+	TokStringConst TokenKind = -3
+
+	// Variable Type Tokens
+	TokString TokenKind = -4
+	TokDouble TokenKind = -5
+	TokVoid   TokenKind = -6
+	TokInt    TokenKind = -7
+	TokBool   TokenKind = -8
+
+	// Keyword Tokens
+	TokDef      TokenKind = -10
+	TokExtern   TokenKind = -11
+	TokSet      TokenKind = -12
+	TokReturn   TokenKind = -13
+	TokConst    TokenKind = -14
+	TokIf       TokenKind = -15
+	TokElse     TokenKind = -16
+	TokWhile    TokenKind = -27
+	TokFor      TokenKind = -28
+	TokBreak    TokenKind = -29
+	TokContinue TokenKind = -30
+	TokTrue     TokenKind = -31
+	TokFalse    TokenKind = -32
+	TokLet      TokenKind = -33
+	TokVar      TokenKind = -34
+
+	// Multi-Character Operator Tokens
+	TokEq          TokenKind = -40
+	TokNe          TokenKind = -41
+	TokLe          TokenKind = -42
+	TokGe          TokenKind = -43
+	TokAnd         TokenKind = -44
+	TokOr          TokenKind = -45
+	TokShl         TokenKind = -46
+	TokShr         TokenKind = -47
+	TokPlusAssign  TokenKind = -48
+	TokMinusAssign TokenKind = -49
+	TokMulAssign   TokenKind = -50
+	TokDivAssign   TokenKind = -51
+	TokArrow       TokenKind = -52
+	TokPow         TokenKind = -53
+	TokRange       TokenKind = -54
+
+	// TokError is returned for malformed input. Its Text holds the error
+	// message and its Code the error code.
+	TokError TokenKind = -98
+	TokEOF   TokenKind = -99
+)
+
+var multiCharOps = map[string]TokenKind{
+	"==": TokEq,
+	"!=": TokNe,
+	"<=": TokLe,
+	">=": TokGe,
+	"&&": TokAnd,
+	"||": TokOr,
+	"<<": TokShl,
+	">>": TokShr,
+	"+=": TokPlusAssign,
+	"-=": TokMinusAssign,
+	"*=": TokMulAssign,
+	"/=": TokDivAssign,
+	"->": TokArrow,
+	"**": TokPow,
+	"..": TokRange,
+}
+
+// IsMultiCharOp reports whether s is an operator token of more than one
+// character, such as <=.
+func IsMultiCharOp(s string) bool {
+	_, ok := multiCharOps[s]
+	return ok
+}
+
+// IsIdentifier reports whether s is a valid identifier that is not a
+// keyword.
+func IsIdentifier(s string) bool {
+	l := NewStringLexer("", s)
+	l.NextToken()
+	return l.CurrTok == TokIdentifier && l.String == s && l.Peek(1).Kind == TokEOF
+}
+
+var keywords = map[string]TokenKind{
+	"def":      TokDef,
+	"extern":   TokExtern,
+	"set":      TokSet,
+	"let":      TokLet,
+	"var":      TokVar,
+	"const":    TokConst,
+	"return":   TokReturn,
+	"if":       TokIf,
+	"else":     TokElse,
+	"while":    TokWhile,
+	"for":      TokFor,
+	"break":    TokBreak,
+	"continue": TokContinue,
+	"true":     TokTrue,
+	"false":    TokFalse,
+	"string":   TokString,
+	"double":   TokDouble,
+	"void":     TokVoid,
+	"int":      TokInt,
+	"bool":     TokBool,
+}
+
+var tokenNames = map[TokenKind]string{
+	TokIdentifier:  "identifier",
+	TokNumVal:      "number",
+	TokStringConst: "string literal",
+	TokError:       "error",
+	TokEOF:         "end of file",
+}
+
+func init() {
+	for text, tok := range keywords {
+		tokenNames[tok] = text
+	}
+	for text, tok := range multiCharOps {
+		tokenNames[tok] = text
+	}
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	if k > 0 {
+		return string(rune(k))
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+func (k TokenKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
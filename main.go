@@ -1,27 +1,275 @@
 package main
 
 import (
+	"Kaleidoscope/diag"
+	"Kaleidoscope/diff"
+	"Kaleidoscope/format"
 	"Kaleidoscope/lexer"
 	"Kaleidoscope/parser"
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 )
 
 func main() {
-	reader := bufio.NewReader(os.Stdin)
+	if len(os.Args) >= 2 && os.Args[1] == "tokens" {
+		dumpTokens(os.Args[2:])
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "fmt" {
+		formatFiles(os.Args[2:])
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "ast" {
+		dumpAST(os.Args[2:])
+		return
+	}
+
+	maxErrors := flag.Int("max-errors", parser.DefaultMaxErrors, "stop after this many errors (0 for no limit)")
+	format := flag.String("format", "text", "diagnostics format: text, json or sarif")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" && *format != "sarif" {
+		fmt.Fprintf(os.Stderr, "unknown diagnostics format %q\n", *format)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	lex := newLexer(flag.Args())
+	parse := parser.NewParser(lex)
+	parse.MaxErrors = *maxErrors
+
+	errs := parse.Shell()
+	switch *format {
+	case "json", "sarif":
+		writeDiagnostics(*format, errs)
+	default:
+		printErrors(lex.Sources(), errs)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeDiagnostics writes errs to stderr as a JSON or SARIF document. The
+// document is written even if there are no errors so that tools can always
+// parse the output.
+func writeDiagnostics(format string, errs []error) {
+	diags := make([]*diag.Diagnostic, 0, len(errs))
+	for _, err := range errs {
+		d, ok := err.(*diag.Diagnostic)
+		if !ok {
+			d = diag.Errorf("", diag.Span{}, "%s", err.Error())
+		}
+		diags = append(diags, d)
+	}
+
+	var err error
+	if format == "sarif" {
+		err = diag.WriteSARIF(os.Stderr, "kscc", diags)
+	} else {
+		err = diag.WriteJSON(os.Stderr, diags)
+	}
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+// printErrors renders errs to stderr, with colour if it is a terminal.
+func printErrors(sources []lexer.Source, errs []error) {
+	renderer := &diag.Renderer{
+		Sources: map[string][]byte{},
+		Color:   isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "",
+	}
+	for _, source := range sources {
+		renderer.Sources[source.File] = source.Src
+	}
 
-	if len(os.Args) == 2 {
-		file, err := os.Open(os.Args[1])
+	for _, err := range errs {
+		if d, ok := err.(*diag.Diagnostic); ok {
+			renderer.Render(os.Stderr, d)
+		} else {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		}
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newLexer creates a lexer over the given files, or over stdin if there are none.
+func newLexer(files []string) *lexer.Lexer {
+	if len(files) == 0 {
+		return lexer.NewFileLexer("<stdin>", bufio.NewReader(os.Stdin))
+	}
+
+	var sources []lexer.Source
+	for _, fileName := range files {
+		src, err := os.ReadFile(fileName)
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
-		reader = bufio.NewReader(file)
+		sources = append(sources, lexer.Source{File: fileName, Src: src})
 	}
+	return lexer.NewMultiLexer(sources...)
+}
 
-	lex := lexer.NewLexer(reader)
-	parse := parser.NewParser(lex)
+// dumpTokens prints the token stream of the given files, one token per line
+// or as a JSON array. It exits with status 1 if any token could not be lexed.
+func dumpTokens(args []string) {
+	flags := flag.NewFlagSet("tokens", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print tokens as JSON")
+	_ = flags.Parse(args)
+
+	toks := newLexer(flags.Args()).Tokens()
+
+	if *asJSON {
+		if toks == nil {
+			toks = []lexer.Token{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(toks); err != nil {
+			log.Fatalln(err.Error())
+		}
+	} else {
+		for _, tok := range toks {
+			fmt.Printf("%s\t%s\t%q\n", tok.Start, tok.Kind, tok.Text)
+		}
+	}
+
+	for _, tok := range toks {
+		if tok.Kind == lexer.TokError {
+			os.Exit(1)
+		}
+	}
+}
 
-	parse.Shell()
+// formatFiles formats the given files, or stdin if there are none. By default
+// the formatted source is printed. It exits with status 1 if any file could
+// not be formatted.
+func formatFiles(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	showDiff := flags.Bool("d", false, "print diffs instead of the formatted source")
+	write := flags.Bool("w", false, "write the result to the file instead of stdout")
+	_ = flags.Parse(args)
 
+	if flags.NArg() == 0 {
+		if *write {
+			log.Fatalln("cannot use -w with stdin")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if !formatFile("<stdin>", src, *list, *showDiff, false) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ok := true
+	for _, fileName := range flags.Args() {
+		src, err := os.ReadFile(fileName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			ok = false
+			continue
+		}
+		ok = formatFile(fileName, src, *list, *showDiff, *write) && ok
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// formatFile formats a single file and reports the result as requested. It
+// returns false if the file could not be formatted.
+func formatFile(fileName string, src []byte, list bool, showDiff bool, write bool) bool {
+	res, errs := format.Source(fileName, src)
+	if len(errs) > 0 {
+		printErrors([]lexer.Source{{File: fileName, Src: src}}, errs)
+		return false
+	}
+
+	if bytes.Equal(src, res) {
+		if !list && !showDiff && !write {
+			os.Stdout.Write(res)
+		}
+		return true
+	}
+
+	if list {
+		fmt.Println(fileName)
+	}
+	if write {
+		info, err := os.Stat(fileName)
+		if err == nil {
+			err = os.WriteFile(fileName, res, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return false
+		}
+	}
+	if showDiff {
+		os.Stdout.Write(diff.Unified(fileName+".orig", fileName, src, res))
+	}
+	if !list && !showDiff && !write {
+		os.Stdout.Write(res)
+	}
+	return true
+}
+
+// dumpAST prints the syntax tree of the given files as JSON. With -d it reads
+// a syntax tree encoded as JSON instead and prints it as source code.
+func dumpAST(args []string) {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	decode := flags.Bool("d", false, "decode a JSON syntax tree and print it as source")
+	_ = flags.Parse(args)
+
+	if *decode {
+		var data []byte
+		var err error
+		if flags.NArg() == 0 {
+			data, err = io.ReadAll(os.Stdin)
+		} else if flags.NArg() == 1 {
+			data, err = os.ReadFile(flags.Arg(0))
+		} else {
+			log.Fatalln("ast -d takes at most one file")
+		}
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		node, err := parser.UnmarshalNode(data)
+		if err == nil {
+			err = format.Node(os.Stdout, node)
+		}
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		return
+	}
+
+	lex := newLexer(flags.Args())
+	prog, errs := parser.NewParser(lex).ParseProgram()
+	if len(errs) > 0 {
+		printErrors(lex.Sources(), errs)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(prog); err != nil {
+		log.Fatalln(err.Error())
+	}
 }
@@ -1,8 +1,9 @@
 package parser
 
 import (
+	"Kaleidoscope/diag"
+	"Kaleidoscope/lexer"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
@@ -14,9 +15,74 @@ import (
 type AST interface {
 	fmt.Stringer
 	CodeGen(block *ir.Block) (interface{}, error)
+	Span() diag.Span
 }
 
+// ASTNode holds the position of a node in the source, from the start of its
+// first token to the end of its last token.
 type ASTNode struct {
+	Start lexer.Pos `json:"start"`
+	End   lexer.Pos `json:"end"`
+}
+
+func (n ASTNode) Span() diag.Span {
+	return diag.Span{Start: n.Start, End: n.End}
+}
+
+// Program is a whole source: its externs, constants and function definitions
+// in source order.
+type Program struct {
+	ASTNode
+	Items []AST `json:"items"`
+}
+
+func (p Program) String() string {
+	s := ""
+	for _, item := range p.Items {
+		s += item.String() + "\n"
+	}
+	return s
+}
+
+// Compile generates code for prog. All externs and functions are declared
+// before any body is generated, so functions may call functions defined after
+// them. Constants are generated next so that they can be used by any function.
+func Compile(prog *Program) []error {
+	var errs []error
+	declared := map[AST]bool{}
+	for _, item := range prog.Items {
+		var err error
+		switch item := item.(type) {
+		case *PrototypeAST:
+			_, err = item.CodeGen(nil)
+		case *FunctionAST:
+			_, err = item.Prototype.CodeGen(nil)
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		declared[item] = true
+	}
+
+	for _, item := range prog.Items {
+		if _, ok := item.(*AssignmentAST); ok {
+			if _, err := item.CodeGen(nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, item := range prog.Items {
+		if fn, ok := item.(*FunctionAST); ok && declared[item] {
+			if _, err := fn.CodeGen(nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
 }
 
 type FuncAST interface {
@@ -37,46 +103,147 @@ func (e Expr) IsExpr() bool {
 }
 
 type Operator struct {
-	Op rune `json:""`
+	Op string `json:""`
 }
 
 func (op Operator) String() string {
-	return string(op.Op)
+	return op.Op
 }
 
 func (op Operator) MarshalJSON() ([]byte, error) {
-	return json.Marshal(string(op.Op))
+	return json.Marshal(op.Op)
+}
+
+func (op *Operator) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &op.Op)
 }
 
 func (op Operator) GetPrecedence() int {
 	return opPrecedence[op.Op]
 }
 
+// IsRightAssoc reports whether op is a right associative binary operator,
+// such as ** in 2 ** 3 ** 2.
+func (op Operator) IsRightAssoc() bool {
+	return rightAssocOps[op.Op]
+}
+
 type AssignmentAST struct {
 	ASTNode
-	VarName string
-	Expr    ExprAST
+	VarName string `json:"var_name"`
+	// Type is the declared type of a const, or the type annotation of a set
+	// statement that must match the type of the variable, or NoType if there
+	// is none
+	Type Type `json:"type,omitempty"`
+	// Operator is set for compound assignments such as +=
+	Operator *Operator `json:"operator,omitempty"`
+	Expr     ExprAST   `json:"expr"`
 }
 
 func (a AssignmentAST) String() string {
-	return a.VarName + " = " + a.Expr.String()
+	if a.Operator != nil {
+		return a.VarName + typeString(a.Type) + " " + a.Operator.Op + "= " + a.Expr.String()
+	}
+	return a.VarName + typeString(a.Type) + " = " + a.Expr.String()
 }
 
 func (a AssignmentAST) CodeGen(block *ir.Block) (interface{}, error) {
-	gen, err := a.Expr.CodeGen(block)
+	expr := a.Expr
+	if a.Operator != nil {
+		expr = &BinaryExprAST{
+			Expr:     Expr{a.ASTNode},
+			Lhs:      &VariableExprAST{Expr: Expr{a.ASTNode}, Name: a.VarName},
+			Operator: a.Operator,
+			Rhs:      a.Expr,
+		}
+	}
+
+	val, endBlock, err := genExpr(block, expr)
 	if err != nil {
 		return nil, err
 	}
-	err = setVar(block, a.VarName, gen.(value.Value))
+	// At top level this is a const declaration
+	if block == nil {
+		val, err = checkType(a.Type, val, a.Expr.Span(), a.VarName)
+		if err == nil {
+			err = declareVar(nil, a.Span(), a.VarName, val, false)
+		}
+	} else {
+		err = setVar(endBlock, a.Span(), a.VarName, a.Type, val)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if endBlock != block {
+		return endBlock, nil
+	}
+	return nil, nil
+}
+
+// typeString returns the type annotation of a declaration of type typ, or ""
+// if it has none.
+func typeString(typ Type) string {
+	if typ == NoType {
+		return ""
+	}
+	return ": " + typ.String()
+}
+
+// VarDeclAST declares a local variable with let, or with var if it is Mutable.
+// The variable is visible from the declaration to the end of the enclosing
+// block.
+type VarDeclAST struct {
+	ASTNode
+	Mutable bool   `json:"mutable"`
+	VarName string `json:"var_name"`
+	// Type is the declared type, or NoType if it is the type of Expr
+	Type Type `json:"type,omitempty"`
+	// Expr is nil for a var declared without a value, which starts out as the
+	// zero value of its type
+	Expr ExprAST `json:"expr,omitempty"`
+}
+
+func (d VarDeclAST) keyword() string {
+	if d.Mutable {
+		return "var"
+	}
+	return "let"
+}
+
+func (d VarDeclAST) String() string {
+	s := d.keyword() + " " + d.VarName + typeString(d.Type)
+	if d.Expr == nil {
+		return s
+	}
+	return s + " = " + d.Expr.String()
+}
+
+func (d VarDeclAST) CodeGen(block *ir.Block) (interface{}, error) {
+	if d.Expr == nil {
+		return nil, declareVar(block, d.Span(), d.VarName, zeroValue(block, d.Type), d.Mutable)
+	}
+
+	val, endBlock, err := genExpr(block, d.Expr)
+	if err != nil {
+		return nil, err
+	}
+	val, err = checkType(d.Type, val, d.Expr.Span(), d.VarName)
+	if err != nil {
+		return nil, err
+	}
+	err = declareVar(endBlock, d.Span(), d.VarName, val, d.Mutable)
+	if err != nil {
+		return nil, err
+	}
+	if endBlock != block {
+		return endBlock, nil
+	}
 	return nil, nil
 }
 
 type ReturnAST struct {
 	ASTNode
-	Expr ExprAST
+	Expr ExprAST `json:"expr"`
 }
 
 func (r ReturnAST) String() string {
@@ -84,16 +251,20 @@ func (r ReturnAST) String() string {
 }
 
 func (r ReturnAST) CodeGen(block *ir.Block) (interface{}, error) {
-	gen, err := r.Expr.CodeGen(block)
+	val, block, err := genExpr(block, r.Expr)
 	if err != nil {
 		return nil, err
 	}
-	return block.NewRet(gen.(value.Value)), nil
+	val, err = checkValue(val, block.Parent.Sig.RetType, "E0330", r.Expr.Span(), "return from "+block.Parent.Name())
+	if err != nil {
+		return nil, err
+	}
+	return block.NewRet(val), nil
 }
 
 type StatementAST struct {
 	ASTNode
-	AST AST
+	AST AST `json:"ast"`
 }
 
 func (s StatementAST) String() string {
@@ -105,9 +276,9 @@ func (s StatementAST) CodeGen(block *ir.Block) (interface{}, error) {
 }
 
 type Param struct {
-	Name string
-	Type Type
-	Len  int
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+	Len  int    `json:"len,omitempty"`
 }
 
 func (p Param) String() string {
@@ -116,12 +287,20 @@ func (p Param) String() string {
 
 type PrototypeAST struct {
 	ASTNode
-	FuncName   string
-	Params     []*Param
-	ReturnType Type
+	FuncName   string   `json:"func_name"`
+	Params     []*Param `json:"params"`
+	ReturnType Type     `json:"return_type"`
 }
 
 func (p PrototypeAST) CodeGen(*ir.Block) (interface{}, error) {
+	// A function may be declared by an extern as well as by its definition
+	if theFunc := getFunc(Module, p.FuncName); theFunc != nil {
+		if !p.matches(theFunc) {
+			return nil, diag.Errorf("E0318", p.Span(), "conflicting declarations of function: %s", p.FuncName)
+		}
+		return theFunc, nil
+	}
+
 	irParams := make([]*ir.Param, len(p.Params))
 	for i, param := range p.Params {
 		irParams[i] = ir.NewParam(param.Name, getIRType(param.Type))
@@ -129,6 +308,19 @@ func (p PrototypeAST) CodeGen(*ir.Block) (interface{}, error) {
 	return Module.NewFunc(p.FuncName, getIRType(p.ReturnType), irParams...), nil
 }
 
+// matches reports whether theFunc has the signature declared by p.
+func (p PrototypeAST) matches(theFunc *ir.Func) bool {
+	if !theFunc.Sig.RetType.Equal(getIRType(p.ReturnType)) || len(theFunc.Params) != len(p.Params) {
+		return false
+	}
+	for i, param := range p.Params {
+		if !theFunc.Params[i].Type().Equal(getIRType(param.Type)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (p PrototypeAST) String() string {
 	s := p.FuncName + "("
 	for i, param := range p.Params {
@@ -143,24 +335,24 @@ func (p PrototypeAST) String() string {
 
 type FunctionAST struct {
 	ASTNode
-	Prototype *PrototypeAST
-	Body      []*StatementAST
+	Prototype *PrototypeAST   `json:"prototype"`
+	Body      []*StatementAST `json:"body"`
 }
 
 func (f FunctionAST) CodeGen(*ir.Block) (interface{}, error) {
-	theFunc := getFunc(Module, f.Prototype.FuncName)
-	if theFunc == nil {
-		gen, err := f.Prototype.CodeGen(nil)
-		if err != nil {
-			return nil, err
-		}
-		theFunc = gen.(*ir.Func)
+	gen, err := f.Prototype.CodeGen(nil)
+	if err != nil {
+		return nil, err
+	}
+	theFunc := gen.(*ir.Func)
+	if len(theFunc.Blocks) > 0 {
+		return nil, diag.Errorf("E0301", f.Prototype.Span(), "function already defined: %s", f.Prototype.FuncName)
 	}
 	entry := theFunc.NewBlock("entry")
 
-	namedValues[theFunc] = map[string]value.Value{}
+	defer enterScope()()
 	for _, param := range theFunc.Params {
-		err := setVar(entry, param.Name(), param)
+		err := declareVar(entry, f.Prototype.Span(), param.Name(), param, true)
 		if err != nil {
 			return nil, err
 		}
@@ -173,7 +365,12 @@ func (f FunctionAST) CodeGen(*ir.Block) (interface{}, error) {
 
 	if currentBlock.Term == nil {
 		if f.Prototype.ReturnType != Void {
-			return nil, errors.New("non-void function: " + f.Prototype.FuncName + " needs return")
+			// Point at the closing }
+			closing := f.End
+			closing.Offset--
+			closing.Column--
+			return nil, diag.Errorf("E0302", f.Prototype.Span(), "non-void function: %s needs return", f.Prototype.FuncName).
+				WithLabel(diag.Span{Start: closing, End: f.End}, "body ends without return")
 		}
 		currentBlock.NewRet(nil)
 	}
@@ -188,29 +385,37 @@ func (f FunctionAST) String() string {
 
 type IfAST struct {
 	ASTNode
-	Cond     ExprAST
-	IfBody   []*StatementAST
-	ElseBody []*StatementAST
+	Cond     ExprAST         `json:"cond"`
+	IfBody   []*StatementAST `json:"if_body"`
+	ElseBody []*StatementAST `json:"else_body,omitempty"`
+	// ElseIf is set instead of ElseBody for else if
+	ElseIf *IfAST `json:"else_if,omitempty"`
+	// Else is the position of the else keyword, or nil if there is none
+	Else *lexer.Pos `json:"else,omitempty"`
 }
 
 func (i IfAST) String() string {
 	s := "if " + i.Cond.String() + " {...}"
-	if i.ElseBody != nil {
+	if i.ElseIf != nil {
+		s = s + " else " + i.ElseIf.String()
+	} else if i.ElseBody != nil {
 		s = s + " else {...}"
 	}
 	return s
 }
 
 func (i IfAST) CodeGen(block *ir.Block) (interface{}, error) {
-	ifBlock := newBlock(block, "if-true-block")
-	afterBlock := newBlock(block, "if-after-block")
-	gen, err := i.Cond.CodeGen(block)
+	condVal, block, err := genExpr(block, i.Cond)
+	if err != nil {
+		return nil, err
+	}
+	condVal, err = condition(block, i.Cond.Span(), condVal)
 	if err != nil {
 		return nil, err
 	}
-	condVal := gen.(value.Value)
 
-	condVal = block.NewFCmp(enum.FPredOGT, condVal, constant.NewFloat(types.Double, 0.0))
+	ifBlock := newBlock(block, "if-true-block")
+	afterBlock := newBlock(block, "if-after-block")
 
 	ifCurrentBlock, err := genStatements(ifBlock, i.IfBody)
 	if err != nil {
@@ -221,9 +426,14 @@ func (i IfAST) CodeGen(block *ir.Block) (interface{}, error) {
 		ifCurrentBlock.NewBr(afterBlock)
 	}
 
-	if i.ElseBody != nil {
+	if i.ElseBody != nil || i.ElseIf != nil {
 		elseBlock := newBlock(block, "if-false-block")
-		elseCurrentBlock, err := genStatements(elseBlock, i.ElseBody)
+		var elseCurrentBlock *ir.Block
+		if i.ElseIf != nil {
+			elseCurrentBlock, err = genStatement(elseBlock, i.ElseIf)
+		} else {
+			elseCurrentBlock, err = genStatements(elseBlock, i.ElseBody)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -242,12 +452,14 @@ func (i IfAST) CodeGen(block *ir.Block) (interface{}, error) {
 
 type WhileAST struct {
 	ASTNode
-	Cond ExprAST
-	Body []*StatementAST
+	// Label names the loop for break and continue, it may be empty
+	Label string          `json:"label,omitempty"`
+	Cond  ExprAST         `json:"cond"`
+	Body  []*StatementAST `json:"body"`
 }
 
 func (w WhileAST) String() string {
-	return "while " + w.Cond.String() + " {...};"
+	return labelString(w.Label) + "while " + w.Cond.String() + " {...};"
 }
 
 func (w WhileAST) CodeGen(block *ir.Block) (interface{}, error) {
@@ -255,17 +467,19 @@ func (w WhileAST) CodeGen(block *ir.Block) (interface{}, error) {
 	loopBlock := newBlock(block, "while-loop")
 	afterBlock := newBlock(block, "while-after")
 
-	gen, err := w.Cond.CodeGen(testBlock)
+	condVal, condBlock, err := genExpr(testBlock, w.Cond)
 	if err != nil {
 		return nil, err
 	}
-	condVal := gen.(value.Value)
-	condVal = testBlock.NewFCmp(enum.FPredOGT, condVal, constant.NewFloat(types.Double, 0.0))
-	testBlock.NewCondBr(condVal, loopBlock, afterBlock)
+	condVal, err = condition(condBlock, w.Cond.Span(), condVal)
+	if err != nil {
+		return nil, err
+	}
+	condBlock.NewCondBr(condVal, loopBlock, afterBlock)
 
 	block.NewBr(testBlock)
 
-	loopCurrentBlock, err := genStatements(loopBlock, w.Body)
+	loopCurrentBlock, err := genLoopBody(loopBlock, w.Label, afterBlock, testBlock, w.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -277,30 +491,249 @@ func (w WhileAST) CodeGen(block *ir.Block) (interface{}, error) {
 	return afterBlock, nil
 }
 
+// ForAST is a C-style for loop. Init, Cond and Step may be nil, a loop
+// without a condition runs until it is left by break or return.
+type ForAST struct {
+	ASTNode
+	Label string          `json:"label,omitempty"`
+	Init  AST             `json:"init,omitempty"`
+	Cond  ExprAST         `json:"cond,omitempty"`
+	Step  AST             `json:"step,omitempty"`
+	Body  []*StatementAST `json:"body"`
+}
+
+func (f ForAST) String() string {
+	s := labelString(f.Label) + "for "
+	if f.Init != nil {
+		s += f.Init.String()
+	}
+	s += "; "
+	if f.Cond != nil {
+		s += f.Cond.String()
+	}
+	s += "; "
+	if f.Step != nil {
+		s += f.Step.String()
+	}
+	return s + " {...};"
+}
+
+func (f ForAST) CodeGen(block *ir.Block) (interface{}, error) {
+	// Variables declared by Init are only visible in the loop
+	defer enterScope()()
+	if f.Init != nil {
+		var err error
+		block, err = genStatement(block, f.Init)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	testBlock := newBlock(block, "for-test")
+	loopBlock := newBlock(block, "for-loop")
+	stepBlock := newBlock(block, "for-step")
+	afterBlock := newBlock(block, "for-after")
+
+	if f.Cond != nil {
+		condVal, condBlock, err := genExpr(testBlock, f.Cond)
+		if err != nil {
+			return nil, err
+		}
+		condVal, err = condition(condBlock, f.Cond.Span(), condVal)
+		if err != nil {
+			return nil, err
+		}
+		condBlock.NewCondBr(condVal, loopBlock, afterBlock)
+	} else {
+		testBlock.NewBr(loopBlock)
+	}
+
+	block.NewBr(testBlock)
+
+	loopCurrentBlock, err := genLoopBody(loopBlock, f.Label, afterBlock, stepBlock, f.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if loopCurrentBlock.Term == nil {
+		loopCurrentBlock.NewBr(stepBlock)
+	}
+
+	if f.Step != nil {
+		stepBlock, err = genStatement(stepBlock, f.Step)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stepBlock.NewBr(testBlock)
+
+	return afterBlock, nil
+}
+
+// ForRangeAST is a loop over the numbers from From up to but not including
+// To, which are evaluated once before the loop.
+type ForRangeAST struct {
+	ASTNode
+	Label   string          `json:"label,omitempty"`
+	VarName string          `json:"var_name"`
+	From    ExprAST         `json:"from"`
+	To      ExprAST         `json:"to"`
+	Body    []*StatementAST `json:"body"`
+}
+
+func (f ForRangeAST) String() string {
+	return labelString(f.Label) + "for " + f.VarName + " in " + f.From.String() + ".." + f.To.String() + " {...};"
+}
+
+func (f ForRangeAST) CodeGen(block *ir.Block) (interface{}, error) {
+	from, block, err := genExpr(block, f.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to, block, err := genExpr(block, f.To)
+	if err != nil {
+		return nil, err
+	}
+
+	from = convertConst(from, to.Type())
+	to = convertConst(to, from.Type())
+	typ := getType(from)
+	if typ != getType(to) || (typ != Double && typ != Int) {
+		return nil, diag.Errorf("E0320", diag.Span{Start: f.From.Span().Start, End: f.To.Span().End},
+			"range bounds must both be double or int")
+	}
+
+	defer enterScope()()
+	err = declareVar(block, f.Span(), f.VarName, from, true)
+	if err != nil {
+		return nil, err
+	}
+	// The loop variable is only changed by the loop itself
+	loopVar := currentScope.vars[f.VarName]
+	loopVar.mutable = false
+
+	testBlock := newBlock(block, "for-test")
+	loopBlock := newBlock(block, "for-loop")
+	stepBlock := newBlock(block, "for-step")
+	afterBlock := newBlock(block, "for-after")
+
+	block.NewBr(testBlock)
+
+	gen, err := retrieveVar(testBlock, f.Span(), f.VarName)
+	if err != nil {
+		return nil, err
+	}
+	var condVal value.Value
+	if typ == Int {
+		condVal = testBlock.NewICmp(enum.IPredSLT, gen.(value.Value), to)
+	} else {
+		condVal = testBlock.NewFCmp(enum.FPredOLT, gen.(value.Value), to)
+	}
+	testBlock.NewCondBr(condVal, loopBlock, afterBlock)
+
+	loopCurrentBlock, err := genLoopBody(loopBlock, f.Label, afterBlock, stepBlock, f.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if loopCurrentBlock.Term == nil {
+		loopCurrentBlock.NewBr(stepBlock)
+	}
+
+	gen, err = retrieveVar(stepBlock, f.Span(), f.VarName)
+	if err != nil {
+		return nil, err
+	}
+	var next value.Value
+	if typ == Int {
+		next = stepBlock.NewAdd(gen.(value.Value), constant.NewInt(types.I64, 1))
+	} else {
+		next = stepBlock.NewFAdd(gen.(value.Value), constant.NewFloat(types.Double, 1.0))
+	}
+	err = store(stepBlock, f.Span(), f.VarName, next, loopVar.val)
+	if err != nil {
+		return nil, err
+	}
+	stepBlock.NewBr(testBlock)
+
+	return afterBlock, nil
+}
+
+// BreakAST leaves the innermost loop, or the loop named by Label.
+type BreakAST struct {
+	ASTNode
+	Label string `json:"label,omitempty"`
+}
+
+func (b BreakAST) String() string {
+	return branchString("break", b.Label)
+}
+
+func (b BreakAST) CodeGen(block *ir.Block) (interface{}, error) {
+	loop, err := findLoop(b.Span(), "break", b.Label)
+	if err != nil {
+		return nil, err
+	}
+	block.NewBr(loop.breakBlock)
+	// Statements after break are unreachable but still need a block
+	return newBlock(block, "break-after"), nil
+}
+
+// ContinueAST starts the next iteration of the innermost loop, or of the loop
+// named by Label.
+type ContinueAST struct {
+	ASTNode
+	Label string `json:"label,omitempty"`
+}
+
+func (c ContinueAST) String() string {
+	return branchString("continue", c.Label)
+}
+
+func (c ContinueAST) CodeGen(block *ir.Block) (interface{}, error) {
+	loop, err := findLoop(c.Span(), "continue", c.Label)
+	if err != nil {
+		return nil, err
+	}
+	block.NewBr(loop.continueBlock)
+	return newBlock(block, "continue-after"), nil
+}
+
 type CallExprAST struct {
 	Expr
-	FuncName string
-	Args     []ExprAST
+	FuncName string    `json:"func_name"`
+	Args     []ExprAST `json:"args"`
 }
 
 func (c CallExprAST) CodeGen(block *ir.Block) (interface{}, error) {
+	if block == nil {
+		return nil, diag.Errorf("E0303", c.Span(), "can not call function at top level")
+	}
 	theFunc := getFunc(Module, c.FuncName)
 	if theFunc == nil {
-		return nil, errors.New("could not find function: " + c.FuncName)
+		return nil, diag.Errorf("E0304", c.Span(), "could not find function: %s", c.FuncName)
+	}
+	if len(c.Args) != len(theFunc.Params) {
+		return nil, diag.Errorf("E0305", c.Span(), "function %s expects %d arguments, got %d", c.FuncName, len(theFunc.Params), len(c.Args))
 	}
 	var args []value.Value
-	for _, arg := range c.Args {
-		gen, err := arg.CodeGen(block)
+	argBlock := block
+	for i, arg := range c.Args {
+		val, endBlock, err := genExpr(argBlock, arg)
 		if err != nil {
 			return nil, err
 		}
+		argBlock = endBlock
 
-		arg := gen.(value.Value)
-
-		args = append(args, arg)
-
+		val, err = checkValue(val, theFunc.Params[i].Type(), "E0331", arg.Span(),
+			fmt.Sprintf("argument %d of %s", i+1, c.FuncName))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, val)
 	}
-	return block.NewCall(theFunc, args...), nil
+	return exprResult(argBlock.NewCall(theFunc, args...), block, argBlock), nil
 }
 
 func (c CallExprAST) String() string {
@@ -317,51 +750,129 @@ func (c CallExprAST) String() string {
 
 type BinaryExprAST struct {
 	Expr
-	Lhs      ExprAST
-	Operator *Operator
-	Rhs      ExprAST
+	Lhs      ExprAST   `json:"lhs"`
+	Operator *Operator `json:"operator"`
+	Rhs      ExprAST   `json:"rhs"`
 }
 
 func (b BinaryExprAST) CodeGen(block *ir.Block) (interface{}, error) {
-	if block == nil {
-		return nil, errors.New("can not use binary expression at top level")
+	if b.Operator.Op == "&&" || b.Operator.Op == "||" {
+		if block == nil {
+			return nil, diag.Errorf("E0306", b.Span(), "can not use binary expression at top level")
+		}
+		return b.genLogical(block)
 	}
-	gen, err := b.Lhs.CodeGen(block)
+	leftValue, endBlock, err := genExpr(block, b.Lhs)
 	if err != nil {
 		return nil, err
 	}
-	leftValue := gen.(value.Value)
 
-	gen, err = b.Rhs.CodeGen(block)
+	rightValue, endBlock, err := genExpr(endBlock, b.Rhs)
 	if err != nil {
 		return nil, err
 	}
-	rightValue := gen.(value.Value)
+
+	// Number constants take the type of the other operand if it is int
+	leftValue = convertConst(leftValue, rightValue.Type())
+	rightValue = convertConst(rightValue, leftValue.Type())
+
+	// Operators on two number constants are evaluated here, so that the int
+	// operators can be used on integer constants such as 0xF0
+	if l, ok := leftValue.(*constant.Float); ok {
+		if r, ok := rightValue.(*constant.Float); ok {
+			if val, ok := foldNumbers(b.Operator.Op, l, r); ok {
+				return exprResult(val, block, endBlock), nil
+			}
+		}
+	}
+	// Only constants can be used at top level
+	if block == nil {
+		return nil, diag.Errorf("E0306", b.Span(), "can not use binary expression at top level")
+	}
 
 	if getType(leftValue) != getType(rightValue) {
-		return nil, errors.New("types in binary expression must match")
+		return nil, diag.Errorf("E0307", b.Span(), "types in binary expression must match")
 	}
 
 	var val value.Value
 
 	switch getType(leftValue) {
 	case Double:
-		val, err = b.handleDoubleOps(block, leftValue, rightValue)
+		val, err = b.handleDoubleOps(endBlock, leftValue, rightValue)
+		break
+	case Int:
+		val, err = b.handleIntOps(endBlock, leftValue, rightValue)
+		break
+	case Bool:
+		val, err = b.handleBoolOps(endBlock, leftValue, rightValue)
 		break
 	case String:
-		val, err = b.handleStringOps(block, leftValue, rightValue)
+		val, err = b.handleStringOps(endBlock, leftValue, rightValue)
 		break
 	default:
 		val = nil
-		err = errors.New("unexpected type in binary expression")
+		err = diag.Errorf("E0308", b.Span(), "unexpected type in binary expression")
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	return val, nil
+	return exprResult(val, block, endBlock), nil
+
+}
+
+// genLogical generates && and ||, which only evaluate their right operand if
+// the left one does not decide the result.
+func (b BinaryExprAST) genLogical(block *ir.Block) (interface{}, error) {
+	leftValue, leftBlock, err := genExpr(block, b.Lhs)
+	if err != nil {
+		return nil, err
+	}
+	leftValue, err = condition(leftBlock, b.Lhs.Span(), leftValue)
+	if err != nil {
+		return nil, err
+	}
+
+	rhsBlock := newBlock(leftBlock, "logical-rhs")
+	afterBlock := newBlock(leftBlock, "logical-after")
+	// The result if the right operand is not evaluated
+	isOr := b.Operator.Op == "||"
+	if isOr {
+		leftBlock.NewCondBr(leftValue, afterBlock, rhsBlock)
+	} else {
+		leftBlock.NewCondBr(leftValue, rhsBlock, afterBlock)
+	}
+
+	rightValue, rightBlock, err := genExpr(rhsBlock, b.Rhs)
+	if err != nil {
+		return nil, err
+	}
+	rightValue, err = condition(rightBlock, b.Rhs.Span(), rightValue)
+	if err != nil {
+		return nil, err
+	}
+	rightBlock.NewBr(afterBlock)
+
+	phi := afterBlock.NewPhi(ir.NewIncoming(constant.NewBool(isOr), leftBlock), ir.NewIncoming(rightValue, rightBlock))
+	return exprResult(phi, block, afterBlock), nil
+}
 
+func (b BinaryExprAST) handleBoolOps(block *ir.Block, leftValue value.Value, rightValue value.Value) (value.Value, error) {
+	switch b.Operator.Op {
+
+	case "==":
+		return block.NewICmp(enum.IPredEQ, leftValue, rightValue), nil
+	case "!=":
+		return block.NewICmp(enum.IPredNE, leftValue, rightValue), nil
+	case "&":
+		return block.NewAnd(leftValue, rightValue), nil
+	case "|":
+		return block.NewOr(leftValue, rightValue), nil
+	case "^":
+		return block.NewXor(leftValue, rightValue), nil
+	}
+	return nil, diag.Errorf("E0309", b.Span(), "unsupported operator for bool: %s", b.Operator.Op)
 }
 
 func (b BinaryExprAST) handleStringOps(block *ir.Block, leftValue value.Value, rightValue value.Value) (value.Value, error) {
@@ -371,7 +882,7 @@ func (b BinaryExprAST) handleStringOps(block *ir.Block, leftValue value.Value, r
 	switch b.Operator.Op {
 	default:
 		val = nil
-		err = errors.New("unsupported operator for double: " + string(b.Operator.Op))
+		err = diag.Errorf("E0309", b.Span(), "unsupported operator for string: %s", b.Operator.Op)
 	}
 	return val, err
 }
@@ -379,35 +890,217 @@ func (b BinaryExprAST) handleStringOps(block *ir.Block, leftValue value.Value, r
 func (b BinaryExprAST) handleDoubleOps(block *ir.Block, leftValue value.Value, rightValue value.Value) (value.Value, error) {
 	switch b.Operator.Op {
 
-	case '*':
+	case "*":
 		return block.NewFMul(leftValue, rightValue), nil
-	case '+':
+	case "+":
 		return block.NewFAdd(leftValue, rightValue), nil
-	case '-':
+	case "-":
 		return block.NewFSub(leftValue, rightValue), nil
-	case '<':
-		cmp := block.NewFCmp(enum.FPredOLT, leftValue, rightValue)
-		return block.NewUIToFP(cmp, types.Double), nil
-	case '>':
-		cmp := block.NewFCmp(enum.FPredOGT, leftValue, rightValue)
-		return block.NewUIToFP(cmp, types.Double), nil
-	case '=':
-		cmp := block.NewFCmp(enum.FPredOEQ, leftValue, rightValue)
-		return block.NewUIToFP(cmp, types.Double), nil
-	case '!':
-		cmp := block.NewFCmp(enum.FPredONE, leftValue, rightValue)
-		return block.NewUIToFP(cmp, types.Double), nil
-	}
-	return nil, errors.New("unsupported operator for double: " + string(b.Operator.Op))
+	case "/":
+		return block.NewFDiv(leftValue, rightValue), nil
+	case "%":
+		return block.NewFRem(leftValue, rightValue), nil
+	case "**":
+		pow := getIntrinsic("llvm.pow.f64", types.Double, types.Double, types.Double)
+		return block.NewCall(pow, leftValue, rightValue), nil
+	case "<":
+		return block.NewFCmp(enum.FPredOLT, leftValue, rightValue), nil
+	case ">":
+		return block.NewFCmp(enum.FPredOGT, leftValue, rightValue), nil
+	case "<=":
+		return block.NewFCmp(enum.FPredOLE, leftValue, rightValue), nil
+	case ">=":
+		return block.NewFCmp(enum.FPredOGE, leftValue, rightValue), nil
+	case "==":
+		return block.NewFCmp(enum.FPredOEQ, leftValue, rightValue), nil
+	case "!=":
+		return block.NewFCmp(enum.FPredONE, leftValue, rightValue), nil
+	}
+	return nil, diag.Errorf("E0309", b.Span(), "unsupported operator for double: %s", b.Operator.Op)
+}
+
+func (b BinaryExprAST) handleIntOps(block *ir.Block, leftValue value.Value, rightValue value.Value) (value.Value, error) {
+	switch b.Operator.Op {
+
+	case "*":
+		return block.NewMul(leftValue, rightValue), nil
+	case "+":
+		return block.NewAdd(leftValue, rightValue), nil
+	case "-":
+		return block.NewSub(leftValue, rightValue), nil
+	case "/":
+		return block.NewSDiv(leftValue, rightValue), nil
+	case "%":
+		return block.NewSRem(leftValue, rightValue), nil
+	case "&":
+		return block.NewAnd(leftValue, rightValue), nil
+	case "|":
+		return block.NewOr(leftValue, rightValue), nil
+	case "^":
+		return block.NewXor(leftValue, rightValue), nil
+	case "<<":
+		return block.NewShl(leftValue, rightValue), nil
+	case ">>":
+		return block.NewAShr(leftValue, rightValue), nil
+	case "<":
+		return block.NewICmp(enum.IPredSLT, leftValue, rightValue), nil
+	case ">":
+		return block.NewICmp(enum.IPredSGT, leftValue, rightValue), nil
+	case "<=":
+		return block.NewICmp(enum.IPredSLE, leftValue, rightValue), nil
+	case ">=":
+		return block.NewICmp(enum.IPredSGE, leftValue, rightValue), nil
+	case "==":
+		return block.NewICmp(enum.IPredEQ, leftValue, rightValue), nil
+	case "!=":
+		return block.NewICmp(enum.IPredNE, leftValue, rightValue), nil
+	}
+	return nil, diag.Errorf("E0309", b.Span(), "unsupported operator for int: %s", b.Operator.Op)
 }
 
 func (b BinaryExprAST) String() string {
-	return "(" + b.Lhs.String() + string(b.Operator.Op) + b.Rhs.String() + ")"
+	return "(" + b.Lhs.String() + b.Operator.Op + b.Rhs.String() + ")"
+}
+
+type UnaryExprAST struct {
+	Expr
+	Operator *Operator `json:"operator"`
+	Operand  ExprAST   `json:"operand"`
+}
+
+func (u UnaryExprAST) CodeGen(block *ir.Block) (interface{}, error) {
+	if !builtinUnaryOps[u.Operator.Op] {
+		call := &CallExprAST{
+			Expr:     u.Expr,
+			FuncName: "unary" + u.Operator.Op,
+			Args:     []ExprAST{u.Operand},
+		}
+		return call.CodeGen(block)
+	}
+
+	operand, endBlock, err := genExpr(block, u.Operand)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fold constants so that negative numbers can be used at top level
+	if c, ok := operand.(*constant.Float); ok {
+		x, _ := c.X.Float64()
+		switch u.Operator.Op {
+		case "-":
+			x = -x
+		case "!":
+			return constant.NewBool(!(x > 0)), nil
+		}
+		return constant.NewFloat(types.Double, x), nil
+	}
+	if c, ok := operand.(*constant.Int); ok && getType(c) == Bool && u.Operator.Op == "!" {
+		return constant.NewBool(c.X.Sign() == 0), nil
+	}
+
+	if block == nil {
+		return nil, diag.Errorf("E0319", u.Span(), "can not use unary expression at top level")
+	}
+	val, err := u.handleOps(endBlock, operand)
+	if err != nil {
+		return nil, err
+	}
+	return exprResult(val, block, endBlock), nil
+}
+
+func (u UnaryExprAST) handleOps(block *ir.Block, operand value.Value) (value.Value, error) {
+	switch getType(operand) {
+	case Double:
+		switch u.Operator.Op {
+		case "-":
+			return block.NewFNeg(operand), nil
+		case "+":
+			return operand, nil
+		default:
+			// The negation of condition, so !x is true exactly when x is not
+			return block.NewFCmp(enum.FPredULE, operand, constant.NewFloat(types.Double, 0.0)), nil
+		}
+	case Int:
+		zero := constant.NewInt(types.I64, 0)
+		switch u.Operator.Op {
+		case "-":
+			return block.NewSub(zero, operand), nil
+		case "+":
+			return operand, nil
+		default:
+			return block.NewICmp(enum.IPredEQ, operand, zero), nil
+		}
+	case Bool:
+		if u.Operator.Op == "!" {
+			return block.NewXor(operand, constant.NewBool(true)), nil
+		}
+		return nil, diag.Errorf("E0309", u.Span(), "unsupported operator for bool: %s", u.Operator.Op)
+	}
+	return nil, diag.Errorf("E0308", u.Span(), "unexpected type in unary expression")
+}
+
+func (u UnaryExprAST) String() string {
+	return "(" + u.Operator.Op + u.Operand.String() + ")"
+}
+
+// IfExprAST is an if expression, if c { a } else { b }. Its value is the value
+// of the branch taken. Else may be another if expression for else if.
+type IfExprAST struct {
+	Expr
+	Cond ExprAST `json:"cond"`
+	Then ExprAST `json:"then"`
+	Else ExprAST `json:"else"`
+}
+
+func (i IfExprAST) CodeGen(block *ir.Block) (interface{}, error) {
+	if block == nil {
+		return nil, diag.Errorf("E0324", i.Span(), "can not use if expression at top level")
+	}
+	condVal, condBlock, err := genExpr(block, i.Cond)
+	if err != nil {
+		return nil, err
+	}
+	condVal, err = condition(condBlock, i.Cond.Span(), condVal)
+	if err != nil {
+		return nil, err
+	}
+
+	thenBlock := newBlock(condBlock, "if-expr-true")
+	elseBlock := newBlock(condBlock, "if-expr-false")
+	afterBlock := newBlock(condBlock, "if-expr-after")
+	condBlock.NewCondBr(condVal, thenBlock, elseBlock)
+
+	thenVal, thenBlock, err := genExpr(thenBlock, i.Then)
+	if err != nil {
+		return nil, err
+	}
+	elseVal, elseBlock, err := genExpr(elseBlock, i.Else)
+	if err != nil {
+		return nil, err
+	}
+
+	thenVal = convertConst(thenVal, elseVal.Type())
+	elseVal = convertConst(elseVal, thenVal.Type())
+	if thenVal.Type().Equal(types.Void) || !thenVal.Type().Equal(elseVal.Type()) {
+		return nil, diag.Errorf("E0323", i.Span(), "branches of if expression must have values of the same type")
+	}
+
+	thenBlock.NewBr(afterBlock)
+	elseBlock.NewBr(afterBlock)
+	phi := afterBlock.NewPhi(ir.NewIncoming(thenVal, thenBlock), ir.NewIncoming(elseVal, elseBlock))
+	return exprResult(phi, block, afterBlock), nil
+}
+
+func (i IfExprAST) String() string {
+	return "if " + i.Cond.String() + " { " + i.Then.String() + " } else { " + i.Else.String() + " }"
 }
 
 type NumberExprAST struct {
 	Expr
-	Val float64
+	Val float64 `json:"val"`
+	// Raw is the literal as written in the source, such as 0x1F or 1_000, or
+	// empty if the number has no source
+	Raw string `json:"raw,omitempty"`
 }
 
 func (n NumberExprAST) CodeGen(*ir.Block) (interface{}, error) {
@@ -418,13 +1111,37 @@ func (n NumberExprAST) String() string {
 	return fmt.Sprintf("%f", n.Val)
 }
 
+type BoolExprAST struct {
+	Expr
+	Val bool `json:"val"`
+}
+
+func (b BoolExprAST) CodeGen(*ir.Block) (interface{}, error) {
+	return constant.NewBool(b.Val), nil
+}
+
+func (b BoolExprAST) String() string {
+	return fmt.Sprintf("%t", b.Val)
+}
+
 type StringExprAST struct {
 	Expr
-	Val string
+	Val string `json:"val"`
+	// Raw is the literal as written in the source, with its quotes and
+	// escapes, or empty if the string has no source
+	Raw string `json:"raw,omitempty"`
 }
 
 func (s StringExprAST) CodeGen(block *ir.Block) (interface{}, error) {
 	charArray := constant.NewCharArrayFromString(s.Val + string(rune(0)))
+	// At top level the string is a global constant
+	if block == nil {
+		global := Module.NewGlobalDef(fmt.Sprintf(".str.%d", len(Module.Globals)), charArray)
+		global.Immutable = true
+		zero := constant.NewInt(types.I64, 0)
+		return constant.NewGetElementPtr(charArray.Type(), global, zero, zero), nil
+	}
+	// Otherwise it is copied to the stack, so that it may be changed
 	x := block.NewAlloca(charArray.Type())
 	block.NewStore(charArray, x)
 	val := block.NewBitCast(x, types.I8Ptr)
@@ -437,11 +1154,11 @@ func (s StringExprAST) String() string {
 
 type VariableExprAST struct {
 	Expr
-	Name string
+	Name string `json:"name"`
 }
 
 func (v VariableExprAST) CodeGen(block *ir.Block) (interface{}, error) {
-	return retrieveVar(block, v.Name)
+	return retrieveVar(block, v.Span(), v.Name)
 }
 
 func (v VariableExprAST) String() string {
@@ -0,0 +1,325 @@
+package parser
+
+import (
+	"Kaleidoscope/diag"
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir/constant"
+)
+
+// TestTruth checks that conditions and ! agree on which doubles are true:
+// those greater than 0.
+func TestTruth(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			src:  "def bool f(double x) { if x { return true; }; return false; }",
+			want: []string{"fcmp ogt double %1, 0.0"},
+		},
+		{
+			src:  "def bool f(double x) { return !x; }",
+			want: []string{"fcmp ule double %1, 0.0"},
+		},
+		{
+			src:  "def bool f(double x) { if !x { return true; }; return false; }",
+			want: []string{"fcmp ule double %1, 0.0"},
+		},
+		{
+			src:  "def bool f(int x) { return !x || x; }",
+			want: []string{"icmp eq i64 %1, 0", "icmp ne i64 %3, 0"},
+		},
+	}
+	for _, test := range tests {
+		if errs := compile(test.src); len(errs) > 0 {
+			t.Fatalf("%s: %v", test.src, errs)
+		}
+		ir := Module.String()
+		for _, want := range test.want {
+			if !strings.Contains(ir, want) {
+				t.Errorf("%s: no %q in\n%s", test.src, want, ir)
+			}
+		}
+	}
+
+	// x and !x are never both true or both false
+	consts := []struct {
+		src  string
+		want bool
+	}{
+		{"const C = !-1;", true},
+		{"const C = !-0.5;", true},
+		{"const C = !0;", true},
+		{"const C = !0.5;", false},
+		{"const C = !2;", false},
+	}
+	for _, test := range consts {
+		if errs := compile(test.src); len(errs) > 0 {
+			t.Fatalf("%s: %v", test.src, errs)
+		}
+		c, ok := globalScope.lookup("C").val.(*constant.Int)
+		if !ok {
+			t.Fatalf("%s: C is %v, want a bool constant", test.src, globalScope.lookup("C").val)
+		}
+		if got := c.X.Sign() != 0; got != test.want {
+			t.Errorf("%s: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestConstFolding(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"7 / 2", 3.5},
+		{"7 % 3", 1},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"0xF0 | 0x0F", 0xFF},
+		{"6 & 3", 2},
+		{"6 ^ 3", 5},
+		{"1 << 2 + 1", 8},
+		{"2 ** 8", 256},
+		{"2 ** 3 ** 2", 512},
+		{"2 * 3 ** 2", 18},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"N * 2", 20},
+	}
+	for _, test := range tests {
+		src := "const N = 10; const C = " + test.expr + ";"
+		if errs := compile(src); len(errs) > 0 {
+			t.Errorf("%s: %v", src, errs)
+			continue
+		}
+		c, ok := globalScope.lookup("C").val.(*constant.Float)
+		if !ok {
+			t.Errorf("%s: C is %v, want a number constant", src, globalScope.lookup("C").val)
+			continue
+		}
+		if got := floatValue(c); got != test.want {
+			t.Errorf("%s: got %v, want %v", src, got, test.want)
+		}
+	}
+
+	for _, src := range []string{"const C = 1.5 << 1;", "const C = true && false;", `const C = "a" + "b";`} {
+		if got := codes(compile(src)); strings.Join(got, " ") != "E0306" {
+			t.Errorf("%s: got errors %v, want [E0306]", src, got)
+		}
+	}
+}
+
+func TestIntOperators(t *testing.T) {
+	tests := []struct {
+		op   string
+		want string
+	}{
+		{"+", "add i64"},
+		{"-", "sub i64"},
+		{"*", "mul i64"},
+		{"/", "sdiv i64"},
+		{"%", "srem i64"},
+		{"&", "and i64"},
+		{"|", "or i64"},
+		{"^", "xor i64"},
+		{"<<", "shl i64"},
+		{">>", "ashr i64"},
+	}
+	for _, test := range tests {
+		src := "def int f(int a, int b) { return a " + test.op + " b; }"
+		if errs := compile(src); len(errs) > 0 {
+			t.Errorf("%s: %v", src, errs)
+			continue
+		}
+		if ir := Module.String(); !strings.Contains(ir, test.want) {
+			t.Errorf("%s: no %q in\n%s", src, test.want, ir)
+		}
+
+		// Number constants are converted to int
+		src = "def int f(int a) { return a " + test.op + " 3; }"
+		if errs := compile(src); len(errs) > 0 {
+			t.Errorf("%s: %v", src, errs)
+		} else if ir := Module.String(); !strings.Contains(ir, test.want) || !strings.Contains(ir, ", 3") {
+			t.Errorf("%s: no %q with 3 in\n%s", src, test.want, ir)
+		}
+	}
+
+	// Constant operands are folded before they are converted
+	if errs := compile("def int f(int a) { return a + 0xF0 % 7; }"); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if ir := Module.String(); !strings.Contains(ir, "add i64 %1, 2") {
+		t.Errorf("0xF0 %% 7 is not folded to 2 in\n%s", ir)
+	}
+}
+
+func TestSetTypeAnnotation(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+		// msg is part of the message of the first error
+		msg string
+	}{
+		{body: "var x: int = 1; set x: int = 2;"},
+		{body: "var x = 1.5; set x: double += 1;"},
+		{body: `var s = "a"; set s: string = "b";`},
+		{body: "var x: int = 1; set x: double = 2;", want: []string{"E0225"}, msg: "type annotation double does not match the type of x"},
+		{body: `var s = "a"; set s: bool = true;`, want: []string{"E0225"}, msg: "type annotation bool"},
+		{body: "var x: int = 1; set x: int = 1.5;", want: []string{"E0317"}},
+		{body: "let y = 1; set y: double = 2;", want: []string{"E0327"}},
+		{body: "set z: int = 1;", want: []string{"E0326"}},
+	}
+	for _, test := range tests {
+		src := "def int f() { " + test.body + " return 0; }"
+		errs := compile(src)
+		if got := codes(errs); strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got errors %v, want %v", test.body, got, test.want)
+			continue
+		}
+		if test.msg != "" && !strings.Contains(errs[0].Error(), test.msg) {
+			t.Errorf("%s: got error %q, want %q", test.body, errs[0], test.msg)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "shadow in block",
+			src:  "def int f() { let x = 1; if true { let x = 2.5; set x = 1; }; return x; }",
+			want: []string{"E0327"},
+		},
+		{
+			name: "shadow outer variable",
+			src:  "def int f() { var x: int = 1; if true { var x = 2.5; set x = 1.5; }; return x; }",
+		},
+		{
+			name: "shadow const",
+			src:  "const N = 1; def double f() { let N = 2.5; return N; }",
+		},
+		{
+			name: "redeclare",
+			src:  "def int f() { let x = 1; var x = 2; return x; }",
+			want: []string{"E0328"},
+		},
+		{
+			name: "use after block",
+			src:  "def int f() { if true { let y = 1; }; return y; }",
+			want: []string{"E0312"},
+		},
+		{
+			name: "loop variable",
+			src:  "def double f() { var s = 0; for i in 0..3 { set s += i; }; return i; }",
+			want: []string{"E0312"},
+		},
+		{
+			name: "declare in loop body",
+			src:  "def int f() { var s: int; while s < 3 { var t = s + 1; set s = t; }; return s; }",
+		},
+		{
+			name: "set const",
+			src:  "const N = 1; def int f() { set N = 2; return 0; }",
+			want: []string{"E0315"},
+		},
+		{
+			name: "scopes end with the function",
+			src:  "def int f() { let x = 1; return x; } def int g() { return x; }",
+			want: []string{"E0312"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := codes(compile(test.src)); strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("got errors %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+		// msg is part of the error message or its notes
+		msg string
+	}{
+		{
+			name: "declaration",
+			src:  "def int f() { let x: int = 1.5; return x; }",
+			want: "E0329",
+			msg:  "mismatched types in declaration of x: expected int, got double",
+		},
+		{
+			name: "const declaration",
+			src:  `const S: string = 1;`,
+			want: "E0329",
+		},
+		{
+			name: "return",
+			src:  "def int f() { return 1.5; }",
+			want: "E0330",
+			msg:  "mismatched types in return from f: expected int, got double",
+		},
+		{
+			name: "return bool",
+			src:  "def double f(double x) { return x > 1; }",
+			want: "E0330",
+			msg:  "use if c { 1 } else { 0 }",
+		},
+		{
+			name: "argument",
+			src:  "def double g(int n) { return 1; } def double f() { return g(1.5); }",
+			want: "E0331",
+			msg:  "mismatched types in argument 1 of g: expected int, got double",
+		},
+		{
+			name: "assignment",
+			src:  `def double f() { var x = 1.5; set x = "s"; return x; }`,
+			want: "E0317",
+			msg:  "mismatched types in assignment to x: expected double, got string",
+		},
+		{
+			name: "binary",
+			src:  `def double f() { return 1.5 + "a"; }`,
+			want: "E0307",
+		},
+		{
+			name: "if expression",
+			src:  `def double f(bool c) { return if c { 1.5 } else { "a" }; }`,
+			want: "E0323",
+		},
+		{
+			name: "condition",
+			src:  `def double f() { if "s" { return 1; }; return 0; }`,
+			want: "E0325",
+		},
+		{
+			name: "set annotation",
+			src:  "def int f() { var x: int = 1; set x: bool = true; return x; }",
+			want: "E0225",
+			msg:  "type annotation bool does not match the type of x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := compile(test.src)
+			if got := codes(errs); strings.Join(got, " ") != test.want {
+				t.Fatalf("got errors %v, want %s", errs, test.want)
+			}
+			d := errs[0].(*diag.Diagnostic)
+			text := strings.Join(append([]string{d.Message}, d.Notes...), "\n")
+			if !strings.Contains(text, test.msg) {
+				t.Errorf("got error %q, want %q", text, test.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,835 @@
+package parser
+
+import (
+	"Kaleidoscope/lexer"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Nodes are encoded as JSON objects with a "kind" field naming the node type,
+// so that trees can be decoded back into the typed AST with UnmarshalNode.
+
+const (
+	kindProgram    = "Program"
+	kindPrototype  = "Prototype"
+	kindFunction   = "Function"
+	kindStatement  = "Statement"
+	kindAssignment = "Assignment"
+	kindVarDecl    = "VarDecl"
+	kindReturn     = "Return"
+	kindIf         = "If"
+	kindWhile      = "While"
+	kindFor        = "For"
+	kindForRange   = "ForRange"
+	kindBreak      = "Break"
+	kindContinue   = "Continue"
+	kindCall       = "Call"
+	kindBinary     = "Binary"
+	kindUnary      = "Unary"
+	kindIfExpr     = "IfExpr"
+	kindNumber     = "Number"
+	kindString     = "String"
+	kindBool       = "Bool"
+	kindVariable   = "Variable"
+)
+
+// marshalKind encodes v, which must encode as an object, with a leading kind
+// field.
+func marshalKind(kind string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	kindData, _ := json.Marshal(kind)
+	out := append([]byte(`{"kind":`), kindData...)
+	if len(data) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...), nil
+}
+
+// UnmarshalNode decodes a node encoded as JSON. The result is a *Program or
+// a pointer to one of the node types, depending on the kind of the node.
+func UnmarshalNode(data []byte) (interface{}, error) {
+	var header struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	var node interface{}
+	switch header.Kind {
+	case kindProgram:
+		node = &Program{}
+	case kindPrototype:
+		node = &PrototypeAST{}
+	case kindFunction:
+		node = &FunctionAST{}
+	case kindStatement:
+		node = &StatementAST{}
+	case kindAssignment:
+		node = &AssignmentAST{}
+	case kindVarDecl:
+		node = &VarDeclAST{}
+	case kindReturn:
+		node = &ReturnAST{}
+	case kindIf:
+		node = &IfAST{}
+	case kindWhile:
+		node = &WhileAST{}
+	case kindFor:
+		node = &ForAST{}
+	case kindForRange:
+		node = &ForRangeAST{}
+	case kindBreak:
+		node = &BreakAST{}
+	case kindContinue:
+		node = &ContinueAST{}
+	case kindCall:
+		node = &CallExprAST{}
+	case kindBinary:
+		node = &BinaryExprAST{}
+	case kindUnary:
+		node = &UnaryExprAST{}
+	case kindIfExpr:
+		node = &IfExprAST{}
+	case kindNumber:
+		node = &NumberExprAST{}
+	case kindString:
+		node = &StringExprAST{}
+	case kindBool:
+		node = &BoolExprAST{}
+	case kindVariable:
+		node = &VariableExprAST{}
+	case "":
+		return nil, errors.New("AST node has no kind")
+	default:
+		return nil, errors.New("unknown AST node kind: " + header.Kind)
+	}
+
+	if err := json.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// unmarshalAST decodes a required node field that holds any AST.
+func unmarshalAST(data json.RawMessage, field string) (AST, error) {
+	if isMissing(data) {
+		return nil, errors.New("missing AST node: " + field)
+	}
+	node, err := UnmarshalNode(data)
+	if err != nil {
+		return nil, err
+	}
+	ast, ok := node.(AST)
+	if !ok {
+		return nil, errors.New("unexpected AST node in " + field)
+	}
+	return ast, nil
+}
+
+// unmarshalExpr decodes a required node field that holds an expression.
+func unmarshalExpr(data json.RawMessage, field string) (ExprAST, error) {
+	ast, err := unmarshalAST(data, field)
+	if err != nil {
+		return nil, err
+	}
+	expr, ok := ast.(ExprAST)
+	if !ok {
+		return nil, errors.New("expected expression in " + field)
+	}
+	return expr, nil
+}
+
+// checkKind returns an error unless the node encoded in data has the given
+// kind. Nodes decoded into typed fields use it, as they are not decoded by
+// UnmarshalNode.
+func checkKind(data []byte, kind string) error {
+	var header struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Kind == "" {
+		return errors.New("AST node has no kind, expected " + kind)
+	}
+	if header.Kind != kind {
+		return errors.New("unexpected AST node kind " + header.Kind + ", expected " + kind)
+	}
+	return nil
+}
+
+// checkBlock returns an error unless the statements decoded from field form
+// a block, which has at least one statement and no null ones.
+func checkBlock(stmts []*StatementAST, field string) error {
+	if len(stmts) == 0 {
+		return errors.New("empty block: " + field)
+	}
+	for _, stmt := range stmts {
+		if stmt == nil {
+			return errors.New("null statement in " + field)
+		}
+	}
+	return nil
+}
+
+// checkItem returns an error unless ast can be a top level item: a function,
+// an extern or a const.
+func checkItem(ast AST) error {
+	switch ast := ast.(type) {
+	case *FunctionAST, *PrototypeAST:
+		return nil
+	case *AssignmentAST:
+		if ast.Operator == nil {
+			return nil
+		}
+	}
+	return errors.New("unexpected AST node in items")
+}
+
+// checkStatement returns an error unless ast can be the statement in field.
+func checkStatement(ast AST, field string) error {
+	switch ast.(type) {
+	case *AssignmentAST, *VarDeclAST, *ReturnAST, *IfAST, *WhileAST, *ForAST, *ForRangeAST, *BreakAST, *ContinueAST:
+		return nil
+	case ExprAST:
+		return nil
+	}
+	return errors.New("unexpected AST node in " + field)
+}
+
+// checkSimpleStatement returns an error unless ast can be the initialiser or
+// step of a for loop given in field.
+func checkSimpleStatement(ast AST, field string) error {
+	switch ast.(type) {
+	case *AssignmentAST, *VarDeclAST:
+		return nil
+	case ExprAST:
+		return nil
+	}
+	return errors.New("unexpected AST node in " + field)
+}
+
+// checkIdent returns an error unless name, found in field, is an identifier.
+func checkIdent(name string, field string) error {
+	if !lexer.IsIdentifier(name) {
+		return errors.New("invalid identifier in " + field + ": " + strconv.Quote(name))
+	}
+	return nil
+}
+
+// checkLabel returns an error unless label is empty or an identifier.
+func checkLabel(label string) error {
+	if label == "" {
+		return nil
+	}
+	return checkIdent(label, "label")
+}
+
+// checkFuncName returns an error unless name is an identifier or names a user
+// defined unary operator, such as unary~.
+func checkFuncName(name string) error {
+	if op := strings.TrimPrefix(name, "unary"); op != name && isUserUnaryOp(op) {
+		return nil
+	}
+	return checkIdent(name, "func_name")
+}
+
+// isUserUnaryOp reports whether op can be defined as a unary operator.
+func isUserUnaryOp(op string) bool {
+	return len(op) == 1 && isUnaryOp(lexer.TokenKind(op[0])) && !builtinUnaryOps[op]
+}
+
+// isMissing reports whether an optional node field is missing or null.
+func isMissing(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
+
+func (p Program) MarshalJSON() ([]byte, error) {
+	type program Program
+	return marshalKind(kindProgram, program(p))
+}
+
+func (p *Program) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.ASTNode = raw.ASTNode
+	p.Items = nil
+	for _, itemData := range raw.Items {
+		item, err := unmarshalAST(itemData, "items")
+		if err != nil {
+			return err
+		}
+		if err := checkItem(item); err != nil {
+			return err
+		}
+		p.Items = append(p.Items, item)
+	}
+	return nil
+}
+
+func (p PrototypeAST) MarshalJSON() ([]byte, error) {
+	type prototype PrototypeAST
+	return marshalKind(kindPrototype, prototype(p))
+}
+
+func (p *PrototypeAST) UnmarshalJSON(data []byte) error {
+	if err := checkKind(data, kindPrototype); err != nil {
+		return err
+	}
+	type prototype PrototypeAST
+	if err := json.Unmarshal(data, (*prototype)(p)); err != nil {
+		return err
+	}
+	if err := checkFuncName(p.FuncName); err != nil {
+		return err
+	}
+	if p.ReturnType == NoType {
+		return errors.New("missing return type of function: " + p.FuncName)
+	}
+	for _, param := range p.Params {
+		if param == nil {
+			return errors.New("null parameter of function: " + p.FuncName)
+		}
+		if err := checkIdent(param.Name, "params"); err != nil {
+			return err
+		}
+		if param.Type == NoType || param.Type == Void {
+			return errors.New("missing type of parameter " + param.Name + " of function: " + p.FuncName)
+		}
+	}
+	return nil
+}
+
+func (f FunctionAST) MarshalJSON() ([]byte, error) {
+	type function FunctionAST
+	return marshalKind(kindFunction, function(f))
+}
+
+func (f *FunctionAST) UnmarshalJSON(data []byte) error {
+	type function FunctionAST
+	if err := json.Unmarshal(data, (*function)(f)); err != nil {
+		return err
+	}
+	if f.Prototype == nil {
+		return errors.New("missing AST node: prototype")
+	}
+	return checkBlock(f.Body, "body")
+}
+
+func (s StatementAST) MarshalJSON() ([]byte, error) {
+	type statement StatementAST
+	return marshalKind(kindStatement, statement(s))
+}
+
+func (s *StatementAST) UnmarshalJSON(data []byte) error {
+	if err := checkKind(data, kindStatement); err != nil {
+		return err
+	}
+	var raw struct {
+		ASTNode
+		AST json.RawMessage `json:"ast"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	ast, err := unmarshalAST(raw.AST, "ast")
+	if err != nil {
+		return err
+	}
+	if err := checkStatement(ast, "ast"); err != nil {
+		return err
+	}
+	s.ASTNode = raw.ASTNode
+	s.AST = ast
+	return nil
+}
+
+func (a AssignmentAST) MarshalJSON() ([]byte, error) {
+	type assignment AssignmentAST
+	return marshalKind(kindAssignment, assignment(a))
+}
+
+func (a *AssignmentAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		VarName  string          `json:"var_name"`
+		Type     Type            `json:"type"`
+		Operator *Operator       `json:"operator"`
+		Expr     json.RawMessage `json:"expr"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkIdent(raw.VarName, "var_name"); err != nil {
+		return err
+	}
+	if raw.Operator != nil && !isAssignOp(raw.Operator.Op) {
+		return errors.New("invalid assignment operator: " + strconv.Quote(raw.Operator.Op))
+	}
+	expr, err := unmarshalExpr(raw.Expr, "expr")
+	if err != nil {
+		return err
+	}
+	*a = AssignmentAST{
+		ASTNode:  raw.ASTNode,
+		VarName:  raw.VarName,
+		Type:     raw.Type,
+		Operator: raw.Operator,
+		Expr:     expr,
+	}
+	return nil
+}
+
+func (d VarDeclAST) MarshalJSON() ([]byte, error) {
+	type varDecl VarDeclAST
+	return marshalKind(kindVarDecl, varDecl(d))
+}
+
+func (d *VarDeclAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Mutable bool            `json:"mutable"`
+		VarName string          `json:"var_name"`
+		Type    Type            `json:"type"`
+		Expr    json.RawMessage `json:"expr"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkIdent(raw.VarName, "var_name"); err != nil {
+		return err
+	}
+	*d = VarDeclAST{
+		ASTNode: raw.ASTNode,
+		Mutable: raw.Mutable,
+		VarName: raw.VarName,
+		Type:    raw.Type,
+	}
+	// A var with a type may have no value
+	if isMissing(raw.Expr) && raw.Mutable && raw.Type != NoType {
+		return nil
+	}
+	var err error
+	d.Expr, err = unmarshalExpr(raw.Expr, "expr")
+	return err
+}
+
+func (r ReturnAST) MarshalJSON() ([]byte, error) {
+	type ret ReturnAST
+	return marshalKind(kindReturn, ret(r))
+}
+
+func (r *ReturnAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Expr json.RawMessage `json:"expr"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	expr, err := unmarshalExpr(raw.Expr, "expr")
+	if err != nil {
+		return err
+	}
+	*r = ReturnAST{ASTNode: raw.ASTNode, Expr: expr}
+	return nil
+}
+
+func (i IfAST) MarshalJSON() ([]byte, error) {
+	type ifAST IfAST
+	return marshalKind(kindIf, ifAST(i))
+}
+
+func (i *IfAST) UnmarshalJSON(data []byte) error {
+	if err := checkKind(data, kindIf); err != nil {
+		return err
+	}
+	var raw struct {
+		ASTNode
+		Cond     json.RawMessage `json:"cond"`
+		IfBody   []*StatementAST `json:"if_body"`
+		ElseBody []*StatementAST `json:"else_body"`
+		ElseIf   *IfAST          `json:"else_if"`
+		Else     *lexer.Pos      `json:"else"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	cond, err := unmarshalExpr(raw.Cond, "cond")
+	if err != nil {
+		return err
+	}
+	if err := checkBlock(raw.IfBody, "if_body"); err != nil {
+		return err
+	}
+	if raw.ElseBody != nil {
+		if err := checkBlock(raw.ElseBody, "else_body"); err != nil {
+			return err
+		}
+	}
+	*i = IfAST{
+		ASTNode:  raw.ASTNode,
+		Cond:     cond,
+		IfBody:   raw.IfBody,
+		ElseBody: raw.ElseBody,
+		ElseIf:   raw.ElseIf,
+		Else:     raw.Else,
+	}
+	return nil
+}
+
+func (w WhileAST) MarshalJSON() ([]byte, error) {
+	type while WhileAST
+	return marshalKind(kindWhile, while(w))
+}
+
+func (w *WhileAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Label string          `json:"label"`
+		Cond  json.RawMessage `json:"cond"`
+		Body  []*StatementAST `json:"body"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkLabel(raw.Label); err != nil {
+		return err
+	}
+	cond, err := unmarshalExpr(raw.Cond, "cond")
+	if err != nil {
+		return err
+	}
+	if err := checkBlock(raw.Body, "body"); err != nil {
+		return err
+	}
+	*w = WhileAST{ASTNode: raw.ASTNode, Label: raw.Label, Cond: cond, Body: raw.Body}
+	return nil
+}
+
+func (f ForAST) MarshalJSON() ([]byte, error) {
+	type forAST ForAST
+	return marshalKind(kindFor, forAST(f))
+}
+
+func (f *ForAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Label string          `json:"label"`
+		Init  json.RawMessage `json:"init"`
+		Cond  json.RawMessage `json:"cond"`
+		Step  json.RawMessage `json:"step"`
+		Body  []*StatementAST `json:"body"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkLabel(raw.Label); err != nil {
+		return err
+	}
+	if err := checkBlock(raw.Body, "body"); err != nil {
+		return err
+	}
+	*f = ForAST{ASTNode: raw.ASTNode, Label: raw.Label, Body: raw.Body}
+	var err error
+	if !isMissing(raw.Init) {
+		if f.Init, err = unmarshalAST(raw.Init, "init"); err != nil {
+			return err
+		}
+		if err := checkSimpleStatement(f.Init, "init"); err != nil {
+			return err
+		}
+	}
+	if !isMissing(raw.Cond) {
+		if f.Cond, err = unmarshalExpr(raw.Cond, "cond"); err != nil {
+			return err
+		}
+	}
+	if !isMissing(raw.Step) {
+		if f.Step, err = unmarshalAST(raw.Step, "step"); err != nil {
+			return err
+		}
+		if err := checkSimpleStatement(f.Step, "step"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f ForRangeAST) MarshalJSON() ([]byte, error) {
+	type forRange ForRangeAST
+	return marshalKind(kindForRange, forRange(f))
+}
+
+func (f *ForRangeAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Label   string          `json:"label"`
+		VarName string          `json:"var_name"`
+		From    json.RawMessage `json:"from"`
+		To      json.RawMessage `json:"to"`
+		Body    []*StatementAST `json:"body"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkLabel(raw.Label); err != nil {
+		return err
+	}
+	if err := checkIdent(raw.VarName, "var_name"); err != nil {
+		return err
+	}
+	from, err := unmarshalExpr(raw.From, "from")
+	if err != nil {
+		return err
+	}
+	to, err := unmarshalExpr(raw.To, "to")
+	if err != nil {
+		return err
+	}
+	if err := checkBlock(raw.Body, "body"); err != nil {
+		return err
+	}
+	*f = ForRangeAST{
+		ASTNode: raw.ASTNode,
+		Label:   raw.Label,
+		VarName: raw.VarName,
+		From:    from,
+		To:      to,
+		Body:    raw.Body,
+	}
+	return nil
+}
+
+func (b BreakAST) MarshalJSON() ([]byte, error) {
+	type breakAST BreakAST
+	return marshalKind(kindBreak, breakAST(b))
+}
+
+func (b *BreakAST) UnmarshalJSON(data []byte) error {
+	type breakAST BreakAST
+	if err := json.Unmarshal(data, (*breakAST)(b)); err != nil {
+		return err
+	}
+	return checkLabel(b.Label)
+}
+
+func (c ContinueAST) MarshalJSON() ([]byte, error) {
+	type continueAST ContinueAST
+	return marshalKind(kindContinue, continueAST(c))
+}
+
+func (c *ContinueAST) UnmarshalJSON(data []byte) error {
+	type continueAST ContinueAST
+	if err := json.Unmarshal(data, (*continueAST)(c)); err != nil {
+		return err
+	}
+	return checkLabel(c.Label)
+}
+
+func (c CallExprAST) MarshalJSON() ([]byte, error) {
+	type call CallExprAST
+	return marshalKind(kindCall, call(c))
+}
+
+func (c *CallExprAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		FuncName string            `json:"func_name"`
+		Args     []json.RawMessage `json:"args"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkIdent(raw.FuncName, "func_name"); err != nil {
+		return err
+	}
+	*c = CallExprAST{Expr: Expr{raw.ASTNode}, FuncName: raw.FuncName}
+	for _, argData := range raw.Args {
+		arg, err := unmarshalExpr(argData, "args")
+		if err != nil {
+			return err
+		}
+		c.Args = append(c.Args, arg)
+	}
+	return nil
+}
+
+func (b BinaryExprAST) MarshalJSON() ([]byte, error) {
+	type binary BinaryExprAST
+	return marshalKind(kindBinary, binary(b))
+}
+
+func (b *BinaryExprAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Lhs      json.RawMessage `json:"lhs"`
+		Operator *Operator       `json:"operator"`
+		Rhs      json.RawMessage `json:"rhs"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Operator == nil {
+		return errors.New("missing operator in binary expression")
+	}
+	if _, ok := opPrecedence[raw.Operator.Op]; !ok {
+		return errors.New("invalid binary operator: " + strconv.Quote(raw.Operator.Op))
+	}
+	lhs, err := unmarshalExpr(raw.Lhs, "lhs")
+	if err != nil {
+		return err
+	}
+	rhs, err := unmarshalExpr(raw.Rhs, "rhs")
+	if err != nil {
+		return err
+	}
+	*b = BinaryExprAST{
+		Expr:     Expr{raw.ASTNode},
+		Lhs:      lhs,
+		Operator: raw.Operator,
+		Rhs:      rhs,
+	}
+	return nil
+}
+
+func (u UnaryExprAST) MarshalJSON() ([]byte, error) {
+	type unary UnaryExprAST
+	return marshalKind(kindUnary, unary(u))
+}
+
+func (u *UnaryExprAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Operator *Operator       `json:"operator"`
+		Operand  json.RawMessage `json:"operand"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Operator == nil {
+		return errors.New("missing operator in unary expression")
+	}
+	if op := raw.Operator.Op; len(op) != 1 || !isUnaryOp(lexer.TokenKind(op[0])) {
+		return errors.New("invalid unary operator: " + strconv.Quote(op))
+	}
+	operand, err := unmarshalExpr(raw.Operand, "operand")
+	if err != nil {
+		return err
+	}
+	*u = UnaryExprAST{
+		Expr:     Expr{raw.ASTNode},
+		Operator: raw.Operator,
+		Operand:  operand,
+	}
+	return nil
+}
+
+func (i IfExprAST) MarshalJSON() ([]byte, error) {
+	type ifExpr IfExprAST
+	return marshalKind(kindIfExpr, ifExpr(i))
+}
+
+func (i *IfExprAST) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ASTNode
+		Cond json.RawMessage `json:"cond"`
+		Then json.RawMessage `json:"then"`
+		Else json.RawMessage `json:"else"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	cond, err := unmarshalExpr(raw.Cond, "cond")
+	if err != nil {
+		return err
+	}
+	thenExpr, err := unmarshalExpr(raw.Then, "then")
+	if err != nil {
+		return err
+	}
+	elseExpr, err := unmarshalExpr(raw.Else, "else")
+	if err != nil {
+		return err
+	}
+	*i = IfExprAST{
+		Expr: Expr{raw.ASTNode},
+		Cond: cond,
+		Then: thenExpr,
+		Else: elseExpr,
+	}
+	return nil
+}
+
+func (n NumberExprAST) MarshalJSON() ([]byte, error) {
+	type number NumberExprAST
+	return marshalKind(kindNumber, number(n))
+}
+
+func (n *NumberExprAST) UnmarshalJSON(data []byte) error {
+	type number NumberExprAST
+	if err := json.Unmarshal(data, (*number)(n)); err != nil {
+		return err
+	}
+	if n.Raw == "" {
+		return nil
+	}
+	// The source text must be a single number literal with the same value
+	lex := lexer.NewStringLexer("", n.Raw)
+	lex.NextToken()
+	if lex.CurrTok != lexer.TokNumVal || lex.NumVal != n.Val || lex.Peek(1).Kind != lexer.TokEOF {
+		return errors.New("raw number " + strconv.Quote(n.Raw) + " does not match its value")
+	}
+	return nil
+}
+
+func (s StringExprAST) MarshalJSON() ([]byte, error) {
+	type str StringExprAST
+	return marshalKind(kindString, str(s))
+}
+
+func (s *StringExprAST) UnmarshalJSON(data []byte) error {
+	type str StringExprAST
+	if err := json.Unmarshal(data, (*str)(s)); err != nil {
+		return err
+	}
+	if s.Raw == "" {
+		return nil
+	}
+	// The source text must be a single string literal with the same value
+	lex := lexer.NewStringLexer("", s.Raw)
+	lex.NextToken()
+	if lex.CurrTok != lexer.TokStringConst || lex.String != s.Val || lex.Peek(1).Kind != lexer.TokEOF {
+		return errors.New("raw string " + strconv.Quote(s.Raw) + " does not match its value")
+	}
+	return nil
+}
+
+func (b BoolExprAST) MarshalJSON() ([]byte, error) {
+	type boolAST BoolExprAST
+	return marshalKind(kindBool, boolAST(b))
+}
+
+func (v VariableExprAST) MarshalJSON() ([]byte, error) {
+	type variable VariableExprAST
+	return marshalKind(kindVariable, variable(v))
+}
+
+func (v *VariableExprAST) UnmarshalJSON(data []byte) error {
+	type variable VariableExprAST
+	if err := json.Unmarshal(data, (*variable)(v)); err != nil {
+		return err
+	}
+	return checkIdent(v.Name, "name")
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"Kaleidoscope/lexer"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestJSONRoundTrip checks that parsed programs decode back from their JSON
+// encoding to the same tree.
+func TestJSONRoundTrip(t *testing.T) {
+	srcs := []string{
+		`extern double printf(string s, double x);
+const N = 0x10;
+const NAME: string = ` + "`raw\\n`" + `;
+def double unary~(double x) { return -x; }
+def int f(int n) {
+	var s: int;
+	let t = "\x41\u{1F600}";
+	for i in 0..n { set s += i; };
+	outer: for var j = 0; j < n; set j += 1 {
+		if j == 2 { continue outer; } else if j > 3 { break outer; } else { set s -= 1; };
+	};
+	while s > 100 && !(s % 2 == 0) { set s /= 2; };
+	return if s < 0 { ~s } else { s ** 2 << 1 };
+}`,
+		`def bool héllo(bool b) { return !b || b; }`,
+	}
+	for _, src := range srcs {
+		prog, errs := NewParser(lexer.NewStringLexer("test.ks", src)).ParseProgram()
+		if len(errs) > 0 {
+			t.Fatalf("%q: %v", src, errs)
+		}
+		data, err := json.Marshal(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := UnmarshalNode(data)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		again, err := json.Marshal(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Errorf("%q: decoding changes the tree:\n%s\n%s", src, data, again)
+		}
+	}
+}
+
+func TestJSONRejected(t *testing.T) {
+	const num = `{"kind":"Number","val":1}`
+	const ret = `{"kind":"Return","expr":` + num + `}`
+	const stmt = `{"kind":"Statement","ast":` + ret + `}`
+	const proto = `{"kind":"Prototype","func_name":"f","params":[],"return_type":"double"}`
+	const function = `{"kind":"Function","prototype":` + proto + `,"body":[` + stmt + `]}`
+	tests := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"binary operator", `{"kind":"Binary","lhs":` + num + `,"operator":"@@","rhs":` + num + `}`, "invalid binary operator"},
+		{"binary paren", `{"kind":"Binary","lhs":` + num + `,"operator":"(","rhs":` + num + `}`, "invalid binary operator"},
+		{"unary paren", `{"kind":"Unary","operator":"(","operand":` + num + `}`, "invalid unary operator"},
+		{"unary binary operator", `{"kind":"Unary","operator":"&&","operand":` + num + `}`, "invalid unary operator"},
+		{"assignment operator", `{"kind":"Assignment","var_name":"x","operator":"%","expr":` + num + `}`, "invalid assignment operator"},
+		{"comparison assignment", `{"kind":"Assignment","var_name":"x","operator":"==","expr":` + num + `}`, "invalid assignment operator"},
+		{"empty name", `{"kind":"Variable","name":""}`, "invalid identifier"},
+		{"keyword name", `{"kind":"Variable","name":"if"}`, "invalid identifier"},
+		{"digit name", `{"kind":"Variable","name":"1x"}`, "invalid identifier"},
+		{"two names", `{"kind":"Variable","name":"a b"}`, "invalid identifier"},
+		{"keyword call", `{"kind":"Call","func_name":"return","args":[]}`, "invalid identifier"},
+		{"keyword variable", `{"kind":"VarDecl","var_name":"let","expr":` + num + `}`, "invalid identifier"},
+		{"keyword loop variable", `{"kind":"ForRange","var_name":"for","from":` + num + `,"to":` + num + `,"body":[` + stmt + `]}`, "invalid identifier"},
+		{"keyword parameter", `{"kind":"Prototype","func_name":"f","params":[{"name":"double","type":"double"}],"return_type":"double"}`, "invalid identifier"},
+		{"built-in unary", `{"kind":"Prototype","func_name":"unary-","params":[{"name":"x","type":"double"}],"return_type":"double"}`, "invalid identifier"},
+		{"keyword label", `{"kind":"Break","label":"while"}`, "invalid identifier"},
+		{"keyword loop label", `{"kind":"While","label":"else","cond":` + num + `,"body":[` + stmt + `]}`, "invalid identifier"},
+		{"function statement", `{"kind":"Statement","ast":` + function + `}`, "unexpected AST node"},
+		{"prototype statement", `{"kind":"Statement","ast":` + proto + `}`, "unexpected AST node"},
+		{"nested statement", `{"kind":"Statement","ast":` + stmt + `}`, "unexpected AST node"},
+		{"return item", `{"kind":"Program","items":[` + ret + `]}`, "unexpected AST node"},
+		{"statement item", `{"kind":"Program","items":[` + stmt + `]}`, "unexpected AST node"},
+		{"compound const", `{"kind":"Program","items":[{"kind":"Assignment","var_name":"x","operator":"+","expr":` + num + `}]}`, "unexpected AST node"},
+		{"return in for", `{"kind":"For","init":` + ret + `,"body":[` + stmt + `]}`, "unexpected AST node"},
+		{"function expression", `{"kind":"Return","expr":` + function + `}`, "expected expression"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := UnmarshalNode([]byte(test.json))
+			if err == nil {
+				t.Fatalf("decoding %s succeeded", test.json)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %q, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestJSONAccepted(t *testing.T) {
+	tests := []string{
+		`{"kind":"Variable","name":"x_1"}`,
+		`{"kind":"Variable","name":"héllo"}`,
+		`{"kind":"Unary","operator":"!","operand":{"kind":"Bool","val":true}}`,
+		`{"kind":"Unary","operator":"~","operand":{"kind":"Number","val":1}}`,
+		`{"kind":"Assignment","var_name":"x","operator":"*","expr":{"kind":"Number","val":1}}`,
+		`{"kind":"Prototype","func_name":"unary~","params":[{"name":"x","type":"double"}],"return_type":"double"}`,
+		`{"kind":"Program","items":[{"kind":"Assignment","var_name":"N","expr":{"kind":"Number","val":1}}]}`,
+	}
+	for _, test := range tests {
+		if _, err := UnmarshalNode([]byte(test)); err != nil {
+			t.Errorf("decoding %s: %v", test, err)
+		}
+	}
+}
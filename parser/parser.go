@@ -1,32 +1,74 @@
 package parser
 
 import (
+	"Kaleidoscope/diag"
 	"Kaleidoscope/lexer"
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
 )
 
 type Parser struct {
 	lexer *lexer.Lexer
+	// Current nesting depth of expressions and blocks
+	depth int
+	// Errors found so far
+	errors []error
+	// MaxErrors is the number of errors after which parsing stops. Zero means
+	// no limit.
+	MaxErrors int
 }
 
+// maxDepth bounds how deeply expressions and blocks may nest so that
+// pathological input cannot exhaust the stack.
+const maxDepth = 1000
+
+// DefaultMaxErrors is the error limit of a new parser.
+const DefaultMaxErrors = 20
+
+// errReported is returned by parse functions that have already recorded their
+// error and could not recover from it.
+var errReported = errors.New("error already reported")
+
 func NewParser(lexer *lexer.Lexer) *Parser {
-	return &Parser{lexer: lexer}
+	return &Parser{lexer: lexer, MaxErrors: DefaultMaxErrors}
 }
 
-func (p *Parser) Shell() {
+// Shell parses the whole input, generates code for it and prints the module.
+// Parsing recovers from errors so that all of them are returned, up to the
+// error limit. The module is only printed if there were no errors.
+func (p *Parser) Shell() []error {
+	prog, errs := p.ParseProgram()
+	if p.tooManyErrors() {
+		return errs
+	}
+
+	errs = append(errs, Compile(prog)...)
+	if p.MaxErrors > 0 && len(errs) > p.MaxErrors {
+		errs = append(errs[:p.MaxErrors], diag.Errorf("E0200", diag.Span{}, "too many errors, stopping after %d", p.MaxErrors))
+	}
+
+	if len(errs) == 0 {
+		fmt.Println(Module)
+	}
+	return errs
+}
+
+// ParseProgram parses the whole input into a Program. Parsing recovers from
+// errors so that all of them are returned, up to the error limit. Items that
+// contained errors are left out of the program, except for the prototypes of
+// functions so that calls to them do not report unknown functions.
+func (p *Parser) ParseProgram() (*Program, []error) {
 	p.lexer.NextToken()
-	for true {
-		//fmt.Printf("> ")
-		var result fmt.Stringer
+	prog := &Program{ASTNode: ASTNode{Start: p.lexer.Token.Start}}
+	for !p.tooManyErrors() {
+		var result AST
 		var err error
+		numErrors := len(p.errors)
 		switch p.lexer.CurrTok {
 		case lexer.TokEOF:
-			//fmt.Println("Received EOF")
-			fmt.Println(Module)
-			return
+			prog.End = p.lexer.Token.End
+			return prog, p.errors
 		case lexer.TokDef:
 			result, err = p.parseFuncDef()
 			break
@@ -41,23 +83,94 @@ func (p *Parser) Shell() {
 			break
 		default:
 			result = nil
-			err = errors.New("unknown token when parsing top level: " + string(rune(p.lexer.CurrTok)))
+			err = p.newError("E0201", "unknown token when parsing top level: "+p.lexer.CurrTok.String())
 			break
 		}
 
 		if err != nil {
-			log.Fatalf("Error during parse: %s\n", err.Error())
+			if err != errReported {
+				p.addError(err)
+			}
+			p.syncTopLevel()
+			keepPrototype(prog, result)
+			continue
+		}
+
+		// Leave out items that recovered from errors
+		if len(p.errors) > numErrors {
+			keepPrototype(prog, result)
+			continue
 		}
 
 		if !isNil(result) {
-			if funcAST, ok := result.(AST); ok {
-				_, err = funcAST.CodeGen(nil)
-				if err != nil {
-					log.Fatalf("Error during code gen: %s\n", err.Error())
-				}
+			prog.Items = append(prog.Items, result)
+		}
+	}
+	return prog, append(p.errors, diag.Errorf("E0200", diag.Span{}, "too many errors, stopping after %d", len(p.errors)))
+}
+
+// keepPrototype adds the prototype of item to prog if item is an extern or a
+// function whose prototype was parsed.
+func keepPrototype(prog *Program, item AST) {
+	switch item := item.(type) {
+	case *PrototypeAST:
+		if item != nil {
+			prog.Items = append(prog.Items, item)
+		}
+	case *FunctionAST:
+		if item != nil && item.Prototype != nil {
+			prog.Items = append(prog.Items, item.Prototype)
+		}
+	}
+}
+
+func (p *Parser) addError(err error) {
+	p.errors = append(p.errors, err)
+}
+
+func (p *Parser) tooManyErrors() bool {
+	return p.MaxErrors > 0 && len(p.errors) >= p.MaxErrors
+}
+
+// isTopLevelTok reports whether the current token can start a top level item.
+func (p *Parser) isTopLevelTok() bool {
+	switch p.lexer.CurrTok {
+	case lexer.TokDef, lexer.TokExtern, lexer.TokConst, lexer.TokEOF:
+		return true
+	}
+	return false
+}
+
+// syncTopLevel skips tokens up to the start of the next top level item.
+func (p *Parser) syncTopLevel() {
+	for !p.isTopLevelTok() {
+		p.lexer.NextToken()
+	}
+}
+
+// syncStatement skips tokens up to the end of the current statement. The ;
+// ending the statement is consumed but a } ending the block is not. It returns
+// false if a top level item was reached instead.
+func (p *Parser) syncStatement() bool {
+	depth := 0
+	for !p.isTopLevelTok() {
+		switch p.lexer.CurrTok {
+		case ';':
+			if depth == 0 {
+				p.lexer.NextToken()
+				return true
 			}
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return true
+			}
+			depth--
 		}
+		p.lexer.NextToken()
 	}
+	return false
 }
 
 func (p *Parser) ParsePrimary() (ExprAST, error) {
@@ -69,18 +182,60 @@ func (p *Parser) ParsePrimary() (ExprAST, error) {
 		return p.parseDoubleConst()
 	case lexer.TokStringConst:
 		return p.parseStringConst()
+	case lexer.TokTrue, lexer.TokFalse:
+		return p.parseBoolConst()
 	case '(':
 		return p.parseParenExpr()
+	case lexer.TokIf:
+		return p.parseIfExpr()
+	case lexer.TokError:
+		return nil, p.newError("", "")
 	default:
-		return nil, errors.New("unknown token when parsing primary: " + string(rune(p.lexer.CurrTok)))
+		return nil, p.newError("E0202", "unknown token when parsing primary: "+p.lexer.CurrTok.String()).
+			WithNote("expected an identifier, number, string, true, false, if expression or ( expression )")
+	}
+}
+
+// parseUnary parses a primary expression preceded by any number of unary
+// operators. Unary operators bind tighter than binary ones.
+func (p *Parser) parseUnary() (ExprAST, error) {
+	if !isUnaryOp(p.lexer.CurrTok) {
+		return p.ParsePrimary()
+	}
+
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	start := p.lexer.Token.Start
+	op := &Operator{Op: p.lexer.Token.Text}
+	// Eat operator
+	p.lexer.NextToken()
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
 	}
+	// ** binds more tightly than a prefix operator, so -a ** 2 is -(a ** 2)
+	operand, err = p.parseBinaryExprRHS(opPrecedence["**"], operand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnaryExprAST{
+		Expr:     Expr{p.node(start)},
+		Operator: op,
+		Operand:  operand,
+	}, nil
 }
 
 func (p *Parser) parseStatement() (*StatementAST, error) {
+	start := p.lexer.Token.Start
 	var ast AST
 	var err error
 	switch p.lexer.CurrTok {
-	case lexer.TokSet:
+	case lexer.TokSet, lexer.TokLet, lexer.TokVar:
 		ast, err = p.parseAssignment()
 		break
 	case lexer.TokReturn:
@@ -90,10 +245,20 @@ func (p *Parser) parseStatement() (*StatementAST, error) {
 		ast, err = p.parseIf()
 		break
 	case lexer.TokWhile:
-		ast, err = p.parseWhile()
+		ast, err = p.parseWhile(start, "")
+		break
+	case lexer.TokFor:
+		ast, err = p.parseFor(start, "")
+		break
+	case lexer.TokBreak, lexer.TokContinue:
+		ast, err = p.parseBranch()
 		break
 	default:
-		ast, err = p.parseExpression()
+		if p.lexer.CurrTok == lexer.TokIdentifier && p.lexer.Peek(1).Kind == ':' {
+			ast, err = p.parseLabeledLoop()
+		} else {
+			ast, err = p.parseExpression()
+		}
 	}
 
 	if err != nil {
@@ -101,18 +266,20 @@ func (p *Parser) parseStatement() (*StatementAST, error) {
 	}
 
 	if p.lexer.CurrTok != ';' {
-		return nil, errors.New("expected ; at end of statement")
+		return nil, p.expectedSemicolon("E0203", "expected ; at end of statement", "add a semicolon after the statement")
 	}
 
 	// Eat ;
 	p.lexer.NextToken()
 
 	return &StatementAST{
-		AST: ast,
+		ASTNode: p.node(start),
+		AST:     ast,
 	}, nil
 }
 
 func (p *Parser) parseIf() (AST, error) {
+	start := p.lexer.Token.Start
 	// Eat "if"
 	p.lexer.NextToken()
 
@@ -127,23 +294,112 @@ func (p *Parser) parseIf() (AST, error) {
 	}
 
 	var elseBody []*StatementAST
+	var elseIf *IfAST
+	var elsePos *lexer.Pos
 	if p.lexer.CurrTok == lexer.TokElse {
+		pos := p.lexer.Token.Start
+		elsePos = &pos
 		// Eat "else"
 		p.lexer.NextToken()
-		elseBody, err = p.parseStatementBlock()
-		if err != nil {
-			return nil, err
+		if p.lexer.CurrTok == lexer.TokIf {
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			defer p.leave()
+			ast, err := p.parseIf()
+			if err != nil {
+				return nil, err
+			}
+			elseIf = ast.(*IfAST)
+		} else {
+			elseBody, err = p.parseStatementBlock()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return &IfAST{
+		ASTNode:  p.node(start),
 		Cond:     cond,
 		IfBody:   ifBody,
 		ElseBody: elseBody,
+		ElseIf:   elseIf,
+		Else:     elsePos,
+	}, nil
+}
+
+// parseIfExpr parses an if expression, if c { a } else { b }, where else may
+// be followed by another if expression instead of a block.
+func (p *Parser) parseIfExpr() (ExprAST, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	start := p.lexer.Token.Start
+	// Eat "if"
+	p.lexer.NextToken()
+
+	cond, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	thenExpr, err := p.parseValueBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.lexer.CurrTok != lexer.TokElse {
+		return nil, p.newError("E0223", "expected else in if expression").
+			WithNote("an if expression needs a value for both branches")
+	}
+	// Eat "else"
+	p.lexer.NextToken()
+
+	var elseExpr ExprAST
+	if p.lexer.CurrTok == lexer.TokIf {
+		elseExpr, err = p.parseIfExpr()
+	} else {
+		elseExpr, err = p.parseValueBlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &IfExprAST{
+		Expr: Expr{p.node(start)},
+		Cond: cond,
+		Then: thenExpr,
+		Else: elseExpr,
 	}, nil
 }
 
-func (p *Parser) parseWhile() (AST, error) {
+// parseValueBlock parses a branch of an if expression, an expression in { }.
+func (p *Parser) parseValueBlock() (ExprAST, error) {
+	if p.lexer.CurrTok != '{' {
+		return nil, p.newError("E0213", "expected { for if expression branch")
+	}
+	// Eat {
+	p.lexer.NextToken()
+
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.lexer.CurrTok != '}' {
+		return nil, p.newError("E0222", "expected } after value of if expression branch")
+	}
+	// Eat }
+	p.lexer.NextToken()
+	return expr, nil
+}
+
+// parseWhile parses a while loop starting at start, which is the start of its
+// label if it has one.
+func (p *Parser) parseWhile(start lexer.Pos, label string) (AST, error) {
 	// Eat "while"
 	p.lexer.NextToken()
 
@@ -158,26 +414,208 @@ func (p *Parser) parseWhile() (AST, error) {
 	}
 
 	return &WhileAST{
-		Cond: cond,
-		Body: whileBody,
+		ASTNode: p.node(start),
+		Label:   label,
+		Cond:    cond,
+		Body:    whileBody,
 	}, nil
 }
 
+// parseFor parses a C-style for loop or a for ... in range loop starting at
+// start, which is the start of its label if it has one.
+func (p *Parser) parseFor(start lexer.Pos, label string) (AST, error) {
+	// Eat "for"
+	p.lexer.NextToken()
+
+	next := p.lexer.Peek(1)
+	if p.lexer.CurrTok == lexer.TokIdentifier && next.Kind == lexer.TokIdentifier && next.Text == "in" {
+		return p.parseForRange(start, label)
+	}
+
+	var init AST
+	var err error
+	if p.lexer.CurrTok != ';' {
+		init, err = p.parseSimpleStatement()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.lexer.CurrTok != ';' {
+		return nil, p.expectedSemicolon("E0220", "expected ; after for loop initialiser", "add a semicolon after the initialiser")
+	}
+	// Eat ;
+	p.lexer.NextToken()
+
+	var cond ExprAST
+	if p.lexer.CurrTok != ';' {
+		cond, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.lexer.CurrTok != ';' {
+		return nil, p.expectedSemicolon("E0220", "expected ; after for loop condition", "add a semicolon after the condition")
+	}
+	// Eat ;
+	p.lexer.NextToken()
+
+	var step AST
+	if p.lexer.CurrTok != '{' {
+		step, err = p.parseSimpleStatement()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := p.parseStatementBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForAST{
+		ASTNode: p.node(start),
+		Label:   label,
+		Init:    init,
+		Cond:    cond,
+		Step:    step,
+		Body:    body,
+	}, nil
+}
+
+// parseForRange parses the rest of a for loop from the loop variable in
+// for i in a..b { ... }.
+func (p *Parser) parseForRange(start lexer.Pos, label string) (AST, error) {
+	varName := p.lexer.String
+	// Eat variable name and "in"
+	p.lexer.NextToken()
+	p.lexer.NextToken()
+
+	from, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.lexer.CurrTok != lexer.TokRange {
+		return nil, p.newError("E0219", "expected .. in for range loop")
+	}
+	// Eat ..
+	p.lexer.NextToken()
+
+	to, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseStatementBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForRangeAST{
+		ASTNode: p.node(start),
+		Label:   label,
+		VarName: varName,
+		From:    from,
+		To:      to,
+		Body:    body,
+	}, nil
+}
+
+// parseSimpleStatement parses the initialiser or step of a for loop, which is
+// a set statement, a declaration or an expression.
+func (p *Parser) parseSimpleStatement() (AST, error) {
+	switch p.lexer.CurrTok {
+	case lexer.TokSet, lexer.TokLet, lexer.TokVar:
+		return p.parseAssignment()
+	}
+	return p.parseExpression()
+}
+
+// parseLabeledLoop parses a loop preceded by a label as in outer: while ...
+func (p *Parser) parseLabeledLoop() (AST, error) {
+	start := p.lexer.Token.Start
+	label := p.lexer.String
+	// Eat label and :
+	p.lexer.NextToken()
+	p.lexer.NextToken()
+
+	switch p.lexer.CurrTok {
+	case lexer.TokWhile:
+		return p.parseWhile(start, label)
+	case lexer.TokFor:
+		return p.parseFor(start, label)
+	}
+	return nil, p.newError("E0221", "expected for or while loop after label "+label)
+}
+
+// parseBranch parses a break or continue statement with an optional label.
+func (p *Parser) parseBranch() (AST, error) {
+	start := p.lexer.Token.Start
+	isBreak := p.lexer.CurrTok == lexer.TokBreak
+	// Eat "break" or "continue"
+	p.lexer.NextToken()
+
+	label := ""
+	if p.lexer.CurrTok == lexer.TokIdentifier {
+		label = p.lexer.String
+		p.lexer.NextToken()
+	}
+
+	if isBreak {
+		return &BreakAST{ASTNode: p.node(start), Label: label}, nil
+	}
+	return &ContinueAST{ASTNode: p.node(start), Label: label}, nil
+}
+
+// parseAssignment parses a set statement, a const item or a let or var
+// declaration.
 func (p *Parser) parseAssignment() (AST, error) {
-	// Eat "set" or "const"
+	start := p.lexer.Token.Start
+	keyword := p.lexer.CurrTok
+	isSet := keyword == lexer.TokSet
+	// Eat "set", "const", "let" or "var"
 	p.lexer.NextToken()
 
 	if p.lexer.CurrTok != lexer.TokIdentifier {
-		return nil, errors.New("expected identifier after set")
+		return nil, p.newError("E0204", "expected identifier after "+keyword.String())
 	}
 
 	ident := p.lexer.String
 	p.lexer.NextToken()
 
-	if p.lexer.CurrTok != '=' {
-		return nil, errors.New("expected = in set statement")
+	// The type annotation of set must repeat the declared type of the
+	// variable
+	typ := NoType
+	if p.lexer.CurrTok == ':' {
+		var err error
+		typ, err = p.parseTypeAnnotation()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A var with a type may be declared without a value
+	isDecl := keyword == lexer.TokLet || keyword == lexer.TokVar
+	if keyword == lexer.TokVar && typ != NoType && p.lexer.CurrTok != '=' {
+		return &VarDeclAST{
+			ASTNode: p.node(start),
+			Mutable: true,
+			VarName: ident,
+			Type:    typ,
+		}, nil
+	}
+
+	var op *Operator
+	if binOp, ok := assignOps[p.lexer.CurrTok]; ok && isSet {
+		op = &Operator{Op: binOp}
+	} else if p.lexer.CurrTok != '=' {
+		err := p.newError("E0205", "expected = in "+keyword.String()+" statement")
+		if keyword == lexer.TokVar {
+			err = err.WithNote("a var without a value needs a type, as in var " + ident + ": double")
+		}
+		return nil, err
 	}
-	// Eat =
+	// Eat = or compound assignment operator
 	p.lexer.NextToken()
 
 	expr, err := p.parseExpression()
@@ -185,13 +623,52 @@ func (p *Parser) parseAssignment() (AST, error) {
 		return nil, err
 	}
 
+	if isDecl {
+		return &VarDeclAST{
+			ASTNode: p.node(start),
+			Mutable: keyword == lexer.TokVar,
+			VarName: ident,
+			Type:    typ,
+			Expr:    expr,
+		}, nil
+	}
+
 	return &AssignmentAST{
-		VarName: ident,
-		Expr:    expr,
+		ASTNode:  p.node(start),
+		VarName:  ident,
+		Type:     typ,
+		Operator: op,
+		Expr:     expr,
 	}, nil
 }
 
+// parseTypeAnnotation parses the : and type following a name in a
+// declaration.
+func (p *Parser) parseTypeAnnotation() (Type, error) {
+	// Eat :
+	p.lexer.NextToken()
+
+	var typ Type
+	switch p.lexer.CurrTok {
+	case lexer.TokDouble:
+		typ = Double
+	case lexer.TokInt:
+		typ = Int
+	case lexer.TokBool:
+		typ = Bool
+	case lexer.TokString:
+		typ = String
+	default:
+		return Invalid, p.newError("E0224", "expected type after :").
+			WithNote("variables can have type double, int, bool or string")
+	}
+	// Eat type
+	p.lexer.NextToken()
+	return typ, nil
+}
+
 func (p *Parser) parseReturn() (AST, error) {
+	start := p.lexer.Token.Start
 	// Eat "return"
 	p.lexer.NextToken()
 
@@ -201,12 +678,18 @@ func (p *Parser) parseReturn() (AST, error) {
 	}
 
 	return &ReturnAST{
-		Expr: expr,
+		ASTNode: p.node(start),
+		Expr:    expr,
 	}, nil
 }
 
 func (p *Parser) parseExpression() (ExprAST, error) {
-	lhsExpr, err := p.ParsePrimary()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	lhsExpr, err := p.parseUnary()
 	if err != nil {
 		return nil, err
 	}
@@ -219,7 +702,7 @@ func (p *Parser) parseBinaryExprRHS(exprPrecedence int, lhsExpr ExprAST) (ExprAS
 	for true {
 
 		tokPrecedence := -1
-		if IsOperator(p.lexer.CurrTok) {
+		if IsOperator(p.lexer.Token) {
 			// parse with consume
 			op, _ := p.parseOperator(false)
 			tokPrecedence = op.GetPrecedence()
@@ -230,19 +713,26 @@ func (p *Parser) parseBinaryExprRHS(exprPrecedence int, lhsExpr ExprAST) (ExprAS
 		}
 
 		op, _ := p.parseOperator(true)
-		rhsExpr, err := p.ParsePrimary()
+		rhsExpr, err := p.parseUnary()
 		if err != nil {
 			return nil, err
 		}
 
 		nextPrecedence := -1
-		if IsOperator(p.lexer.CurrTok) {
+		if IsOperator(p.lexer.Token) {
 			// Parse without consume
 			nextOp, _ := p.parseOperator(false)
 			nextPrecedence = nextOp.GetPrecedence()
 		}
 
-		if tokPrecedence < nextPrecedence {
+		// A right associative operator takes the following operators of the
+		// same precedence into its right operand
+		if op.IsRightAssoc() && tokPrecedence == nextPrecedence {
+			rhsExpr, err = p.parseBinaryExprRHS(tokPrecedence, rhsExpr)
+			if rhsExpr == nil {
+				return nil, err
+			}
+		} else if tokPrecedence < nextPrecedence {
 			rhsExpr, err = p.parseBinaryExprRHS(tokPrecedence+1, rhsExpr)
 			if rhsExpr == nil {
 				return nil, err
@@ -250,6 +740,10 @@ func (p *Parser) parseBinaryExprRHS(exprPrecedence int, lhsExpr ExprAST) (ExprAS
 		}
 
 		lhsExpr = &BinaryExprAST{
+			Expr: Expr{ASTNode{
+				Start: lhsExpr.Span().Start,
+				End:   rhsExpr.Span().End,
+			}},
 			Lhs:      lhsExpr,
 			Operator: op,
 			Rhs:      rhsExpr,
@@ -260,15 +754,18 @@ func (p *Parser) parseBinaryExprRHS(exprPrecedence int, lhsExpr ExprAST) (ExprAS
 }
 
 func (p *Parser) parseExternFunc() (*PrototypeAST, error) {
+	start := p.lexer.Token.Start
 	// Eat 'extern'
 	p.lexer.NextToken()
 	prototype, err := p.parseFuncPrototype()
 	if err != nil {
 		return nil, err
 	}
+	prototype.Start = start
 
 	if p.lexer.CurrTok != ';' {
-		return nil, errors.New("expected ; after extern statement")
+		// Return the prototype so that calls to the function still resolve
+		return prototype, p.expectedSemicolon("E0206", "expected ; after extern statement", "add a semicolon after the prototype")
 	}
 	// Eat ;
 	p.lexer.NextToken()
@@ -277,7 +774,7 @@ func (p *Parser) parseExternFunc() (*PrototypeAST, error) {
 }
 
 func (p *Parser) parseFuncPrototype() (*PrototypeAST, error) {
-
+	start := p.lexer.Token.Start
 	var retType Type
 	var err error
 	switch p.lexer.CurrTok {
@@ -293,9 +790,17 @@ func (p *Parser) parseFuncPrototype() (*PrototypeAST, error) {
 		retType = Void
 		err = nil
 		break
+	case lexer.TokInt:
+		retType = Int
+		err = nil
+		break
+	case lexer.TokBool:
+		retType = Bool
+		err = nil
+		break
 	default:
 		retType = Invalid
-		err = errors.New("expected function return type before name")
+		err = p.newError("E0207", "expected function return type before name")
 	}
 
 	p.lexer.NextToken()
@@ -304,13 +809,27 @@ func (p *Parser) parseFuncPrototype() (*PrototypeAST, error) {
 	}
 
 	if p.lexer.CurrTok != lexer.TokIdentifier {
-		return nil, errors.New("invalid identifier for function definition")
+		return nil, p.newError("E0208", "invalid identifier for function definition")
 	}
 	funcName := p.lexer.String
 	p.lexer.NextToken()
 
+	// unary followed by an operator defines that unary operator
+	isUnary := false
+	if funcName == "unary" && isUnaryOp(p.lexer.CurrTok) {
+		if builtinUnaryOps[p.lexer.Token.Text] {
+			return nil, p.newError("E0218", "can not redefine built-in unary operator "+p.lexer.Token.Text)
+		}
+		isUnary = true
+		funcName += p.lexer.Token.Text
+		p.lexer.NextToken()
+	} else if funcName == "unary" && isPunctuation(p.lexer.Token) && p.lexer.CurrTok != '(' {
+		return nil, p.newError("E0218", "can not define unary operator "+p.lexer.Token.Text).
+			WithNote("~ is the only unary operator that can be defined")
+	}
+
 	if p.lexer.CurrTok != '(' {
-		return nil, errors.New("expected ( for function definition")
+		return nil, p.newError("E0209", "expected ( for function definition")
 	}
 
 	// Eat (
@@ -327,7 +846,7 @@ func (p *Parser) parseFuncPrototype() (*PrototypeAST, error) {
 			params = append(params, param)
 
 			if p.lexer.CurrTok != ',' && p.lexer.CurrTok != ')' {
-				return nil, errors.New("expected , or ) in function prototype")
+				return nil, p.newError("E0210", "expected , or ) in function prototype")
 			}
 
 			currTok := p.lexer.CurrTok
@@ -343,7 +862,13 @@ func (p *Parser) parseFuncPrototype() (*PrototypeAST, error) {
 		p.lexer.NextToken()
 	}
 
+	if isUnary && len(params) != 1 {
+		return nil, diag.Errorf("E0218", diag.Span{Start: start, End: p.lexer.Prev.End},
+			"unary operator %s must have exactly one parameter", funcName)
+	}
+
 	protoype := &PrototypeAST{
+		ASTNode:    p.node(start),
 		FuncName:   funcName,
 		Params:     params,
 		ReturnType: retType,
@@ -365,9 +890,17 @@ func (p *Parser) parseParam() (*Param, error) {
 		typ = Double
 		err = nil
 		break
+	case lexer.TokInt:
+		typ = Int
+		err = nil
+		break
+	case lexer.TokBool:
+		typ = Bool
+		err = nil
+		break
 	default:
 		typ = Invalid
-		err = errors.New("expected type for function parameter")
+		err = p.newError("E0211", "expected type for function parameter")
 	}
 
 	p.lexer.NextToken()
@@ -376,7 +909,7 @@ func (p *Parser) parseParam() (*Param, error) {
 	}
 
 	if p.lexer.CurrTok != lexer.TokIdentifier {
-		return nil, errors.New("invalid identifier for function parameter")
+		return nil, p.newError("E0212", "invalid identifier for function parameter")
 	}
 	paramName := p.lexer.String
 	p.lexer.NextToken()
@@ -389,6 +922,7 @@ func (p *Parser) parseParam() (*Param, error) {
 }
 
 func (p *Parser) parseFuncDef() (*FunctionAST, error) {
+	start := p.lexer.Token.Start
 	// Eat 'def'
 	p.lexer.NextToken()
 
@@ -399,10 +933,12 @@ func (p *Parser) parseFuncDef() (*FunctionAST, error) {
 
 	body, err := p.parseStatementBlock()
 	if err != nil {
-		return nil, err
+		// Return the prototype so that calls to the function still resolve
+		return &FunctionAST{ASTNode: p.node(start), Prototype: prototype}, err
 	}
 
 	functionAST := &FunctionAST{
+		ASTNode:   p.node(start),
 		Prototype: prototype,
 		Body:      body,
 	}
@@ -411,8 +947,13 @@ func (p *Parser) parseFuncDef() (*FunctionAST, error) {
 }
 
 func (p *Parser) parseStatementBlock() ([]*StatementAST, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
 	if p.lexer.CurrTok != '{' {
-		return nil, errors.New("expected { for statement block")
+		return nil, p.newError("E0213", "expected { for statement block")
 	}
 	// Eat {
 	p.lexer.NextToken()
@@ -422,12 +963,17 @@ func (p *Parser) parseStatementBlock() ([]*StatementAST, error) {
 	// Parse statements
 	for true {
 		stmt, err := p.parseStatement()
-		if err != nil {
+		if err == errReported {
 			return nil, err
+		} else if err != nil {
+			p.addError(err)
+			if p.tooManyErrors() || !p.syncStatement() {
+				return nil, errReported
+			}
+		} else {
+			body = append(body, stmt)
 		}
 
-		body = append(body, stmt)
-
 		if p.lexer.CurrTok == '}' {
 			break
 		}
@@ -439,16 +985,19 @@ func (p *Parser) parseStatementBlock() ([]*StatementAST, error) {
 }
 
 func (p *Parser) parseIdentifierExpr() (ExprAST, error) {
+	start := p.lexer.Token.Start
 	id := p.lexer.String
 	p.lexer.NextToken()
 
 	if p.lexer.CurrTok != '(' {
 		varAST := &VariableExprAST{
+			Expr: Expr{p.node(start)},
 			Name: id,
 		}
 		return varAST, nil
 	}
 
+	open := p.lexer.Token
 	// Eat (
 	p.lexer.NextToken()
 	var args []ExprAST
@@ -461,7 +1010,8 @@ func (p *Parser) parseIdentifierExpr() (ExprAST, error) {
 			args = append(args, arg)
 
 			if p.lexer.CurrTok != ',' && p.lexer.CurrTok != ')' {
-				return nil, errors.New("expected , or ) in function call")
+				return nil, p.newError("E0214", "expected , or ) in function call").
+					WithLabel(diag.TokenSpan(open), "argument list opened here")
 			}
 
 			currTok := p.lexer.CurrTok
@@ -478,31 +1028,46 @@ func (p *Parser) parseIdentifierExpr() (ExprAST, error) {
 	}
 
 	callExpr := CallExprAST{
+		Expr:     Expr{p.node(start)},
 		FuncName: id,
 		Args:     args,
 	}
 
-	return callExpr, nil
+	return &callExpr, nil
 
 }
 
 func (p *Parser) parseDoubleConst() (ExprAST, error) {
 	numAST := NumberExprAST{
 		Val: p.lexer.NumVal,
+		Raw: p.lexer.String,
 	}
 	p.lexer.NextToken()
+	numAST.ASTNode = p.node(p.lexer.Prev.Start)
 	return &numAST, nil
 }
 
 func (p *Parser) parseStringConst() (ExprAST, error) {
 	strAST := StringExprAST{
 		Val: p.lexer.String,
+		Raw: p.lexer.Token.Raw,
 	}
 	p.lexer.NextToken()
+	strAST.ASTNode = p.node(p.lexer.Prev.Start)
 	return &strAST, nil
 }
 
+func (p *Parser) parseBoolConst() (ExprAST, error) {
+	boolAST := BoolExprAST{
+		Val: p.lexer.CurrTok == lexer.TokTrue,
+	}
+	p.lexer.NextToken()
+	boolAST.ASTNode = p.node(p.lexer.Prev.Start)
+	return &boolAST, nil
+}
+
 func (p *Parser) parseParenExpr() (ExprAST, error) {
+	open := p.lexer.Token
 	// Consume '('
 	p.lexer.NextToken()
 
@@ -512,7 +1077,8 @@ func (p *Parser) parseParenExpr() (ExprAST, error) {
 	}
 
 	if p.lexer.CurrTok != ')' {
-		return nil, errors.New("expected closing ) for expression")
+		return nil, p.newError("E0215", "expected closing ) for expression").
+			WithLabel(diag.TokenSpan(open), "unclosed ( opened here")
 	}
 	p.lexer.NextToken()
 
@@ -520,10 +1086,10 @@ func (p *Parser) parseParenExpr() (ExprAST, error) {
 }
 
 func (p *Parser) parseOperator(consume bool) (*Operator, error) {
-	if !IsOperator(p.lexer.CurrTok) {
-		return nil, errors.New("invalid operator between expressions")
+	if !IsOperator(p.lexer.Token) {
+		return nil, p.newError("E0216", "invalid operator between expressions")
 	}
-	operator := &Operator{Op: rune(p.lexer.CurrTok)}
+	operator := &Operator{Op: p.lexer.Token.Text}
 
 	if consume {
 		p.lexer.NextToken()
@@ -531,6 +1097,52 @@ func (p *Parser) parseOperator(consume bool) (*Operator, error) {
 	return operator, nil
 }
 
+// node returns an ASTNode spanning from start to the end of the last token
+// consumed.
+func (p *Parser) node(start lexer.Pos) ASTNode {
+	return ASTNode{Start: start, End: p.lexer.Prev.End}
+}
+
 func isNil(i interface{}) bool {
-	return i == nil || reflect.ValueOf(i).IsNil()
+	if i == nil {
+		return true
+	}
+	v := reflect.ValueOf(i)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// expectedSemicolon creates an error for a missing ; pointing just after the
+// previous token, where the ; belongs.
+func (p *Parser) expectedSemicolon(code string, msg string, fix string) *diag.Diagnostic {
+	d := p.newError(code, msg)
+	if p.lexer.CurrTok == lexer.TokError {
+		return d
+	}
+
+	end := diag.Span{Start: p.lexer.Prev.End, End: p.lexer.Prev.End}
+	d.Span = end
+	return d.WithLabel(diag.TokenSpan(p.lexer.Token), "unexpected "+p.lexer.CurrTok.String()).
+		WithFix(end, ";", fix)
+}
+
+// enter increases the nesting depth, failing if it gets too deep.
+func (p *Parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.newError("E0217", "code is nested too deeply")
+	}
+	return nil
+}
+
+func (p *Parser) leave() {
+	p.depth--
+}
+
+// newError creates a parse error located at the current token. If the lexer
+// failed to produce the current token its error is reported instead.
+func (p *Parser) newError(code string, msg string) *diag.Diagnostic {
+	if p.lexer.CurrTok == lexer.TokError {
+		return diag.Errorf(p.lexer.Token.Code, diag.TokenSpan(p.lexer.Token), "%s", p.lexer.String)
+	}
+	return diag.Errorf(code, diag.TokenSpan(p.lexer.Token), "%s", msg)
 }
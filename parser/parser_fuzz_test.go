@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"Kaleidoscope/lexer"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addExamples adds the example programs to the seed corpus of f.
+func addExamples(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.ks"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(files) == 0 {
+		f.Fatal("no example programs found")
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+}
+
+// FuzzParser checks that parsing any input and generating code for it
+// terminates without panicking.
+func FuzzParser(f *testing.F) {
+	addExamples(f)
+	f.Add([]byte("def double f(double x) { return ((((x"))
+	f.Add([]byte("def double f() { if 1 { return 1; } else { return 2; }; }"))
+	f.Add([]byte("const X = \"a\"; def void f() { f(X, 1); return; }"))
+	f.Add([]byte("def int f(int n) { var s: int; for i in 0..n { set s += i; }; return s; }"))
+	f.Add([]byte("def bool f() { outer: while true { break outer; }; return false || !true; }"))
+	f.Fuzz(func(t *testing.T, src []byte) {
+		reset()
+		p := NewParser(lexer.NewBytesLexer("fuzz.ks", src))
+		prog, _ := p.ParseProgram()
+		if !p.tooManyErrors() {
+			Compile(prog)
+		}
+	})
+}
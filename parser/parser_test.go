@@ -0,0 +1,215 @@
+package parser
+
+import (
+	"Kaleidoscope/diag"
+	"Kaleidoscope/lexer"
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+)
+
+// reset starts a new empty module with no constants.
+func reset() {
+	Module = ir.NewModule()
+	globalScope = newScope(nil)
+	currentScope = globalScope
+	loops = nil
+}
+
+// compile parses src and generates code for it in a new module, returning
+// the errors of both.
+func compile(src string) []error {
+	reset()
+	p := NewParser(lexer.NewBytesLexer("test.ks", []byte(src)))
+	prog, errs := p.ParseProgram()
+	if p.tooManyErrors() {
+		return errs
+	}
+	return append(errs, Compile(prog)...)
+}
+
+// codes returns the diagnostic codes of errs.
+func codes(errs []error) []string {
+	var codes []string
+	for _, err := range errs {
+		if d, ok := err.(*diag.Diagnostic); ok {
+			codes = append(codes, d.Code)
+		} else {
+			codes = append(codes, err.Error())
+		}
+	}
+	return codes
+}
+
+func TestParseErrorsInFunctions(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "broken statement",
+			src: `def double fact(double n) {
+	if n < 2 { return 1 };
+	return n * fact(n - 1);
+}
+def double twice(double n) { return fact(n) + fact(n); }
+def double main() { return fact(5) * twice(3); }`,
+			want: []string{"E0203"},
+		},
+		{
+			name: "unclosed body",
+			src: `def double main() { return fact(5); }
+def double fact(double n) { return ((n; }`,
+			want: []string{"E0215"},
+		},
+		{
+			name: "extern without semicolon",
+			src: `extern double sin(double x)
+def double main() { return sin(1) + sin(2); }`,
+			want: []string{"E0206"},
+		},
+		{
+			name: "broken prototype",
+			src: `def double fact(double n { return 1; }
+def double main() { return 1; }`,
+			want: []string{"E0210"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := codes(compile(test.src))
+			if len(got) != len(test.want) {
+				t.Fatalf("got errors %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got errors %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestItemSpans(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: "extern double sin(double x);", want: "extern double sin(double x)"},
+		{src: "  extern void f() ;", want: "extern void f()"},
+		{src: "def int one() { return 1; }", want: "def int one() { return 1; }"},
+		{src: "const N = 1 + 2;", want: "const N = 1 + 2"},
+	}
+	for _, test := range tests {
+		prog, errs := NewParser(lexer.NewBytesLexer("test.ks", []byte(test.src))).ParseProgram()
+		if len(errs) > 0 {
+			t.Fatalf("%q: %v", test.src, errs)
+		}
+		if len(prog.Items) != 1 {
+			t.Fatalf("%q: got %d items, want 1", test.src, len(prog.Items))
+		}
+		span := prog.Items[0].Span()
+		if got := test.src[span.Start.Offset:span.End.Offset]; got != test.want {
+			t.Errorf("%q: got span %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestUnaryOperators(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{src: "def double f(double x) { return -x + +x; }"},
+		{src: "def bool f(bool b) { return !!b; }"},
+		{src: "def double unary~(double x) { return 0 - x; } def double f(double x) { return ~x; }"},
+		{src: "def double f(double x) { return :x; }", want: []string{"E0202"}},
+		{src: "def double f(double x) { return .x; }", want: []string{"E0202"}},
+		{src: "def double f(double x) { return &x; }", want: []string{"E0202"}},
+		{src: "def double unary!(double x) { return x; }", want: []string{"E0218"}},
+		{src: "def double unary&(double x) { return x; }", want: []string{"E0218"}},
+		{src: "def double unary:(double x) { return x; }", want: []string{"E0218"}},
+		{src: "def double unary**(double x) { return x; }", want: []string{"E0218"}},
+		{src: "def double unary~(double x, double y) { return x; }", want: []string{"E0218"}},
+	}
+	for _, test := range tests {
+		got := codes(compile(test.src))
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got errors %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+		// lines of the errors
+		lines []int
+	}{
+		{
+			name: "statements",
+			src: `def double f(double x) {
+	let a = ;
+	let b = 2
+	return a + b;
+}`,
+			want:  []string{"E0202", "E0203"},
+			lines: []int{2, 3},
+		},
+		{
+			name: "items",
+			src: `extern double sin(double x)
+const = 1;
+def double g() { return sin(1); }
+@
+def double h( { return 1; }`,
+			want:  []string{"E0206", "E0204", "E0201", "E0211"},
+			lines: []int{1, 2, 4, 5},
+		},
+		{
+			name: "nested blocks",
+			src: `def double f(double x) {
+	if x { let a = (; } else { return 1 };
+	while x { set x = x - ; };
+	return x;
+}`,
+			want:  []string{"E0202", "E0203", "E0202"},
+			lines: []int{2, 2, 3},
+		},
+		{
+			name:  "lexer errors",
+			src:   "def double f() { let s = \"\\q\"; let n = 0x; return 1; }",
+			want:  []string{"E0102", "E0104"},
+			lines: []int{1, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := compile(test.src)
+			if got := codes(errs); strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Fatalf("got errors %v, want %v", errs, test.want)
+			}
+			for i, err := range errs {
+				if line := err.(*diag.Diagnostic).Span.Start.Line; line != test.lines[i] {
+					t.Errorf("error %v is on line %d, want %d", err, line, test.lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestErrorLimit(t *testing.T) {
+	src := strings.Repeat("def double f() { return ; }\n", 30)
+	p := NewParser(lexer.NewStringLexer("test.ks", src))
+	p.MaxErrors = 5
+	_, errs := p.ParseProgram()
+	got := codes(errs)
+	want := "E0202 E0202 E0202 E0202 E0202 E0200"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got errors %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package parser
+
+// An ApplyFunc is called by Apply for each node, with a Cursor describing
+// where the node is.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses a syntax tree recursively, starting with root, and calls
+// pre and post for each node. Either may be nil.
+//
+// If pre returns false, the children of the node are not traversed and post
+// is not called for it. If post returns false, the traversal stops and Apply
+// returns immediately.
+//
+// The tree may be changed through the Cursor during the traversal. Apply
+// returns the root, which differs from the given root if it was replaced.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	parent := &rootNode{node: root}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.node
+	}()
+
+	a := &application{pre: pre, post: post}
+	a.apply(parent, "Node", func(n Node) { parent.node = n }, nil, root)
+	return
+}
+
+var abort = new(int)
+
+// rootNode is the parent of the root of the tree passed to Apply, so that the
+// root can be replaced like any other node.
+type rootNode struct {
+	ASTNode
+	node Node
+}
+
+// A Cursor describes a node encountered during Apply.
+type Cursor struct {
+	parent Node
+	name   string
+	node   Node
+	// set replaces the node in its parent
+	set func(Node)
+	// list is the slice the node is in and iter the position in it, or nil
+	list nodeList
+	iter *iterator
+}
+
+// Node returns the current node.
+func (c *Cursor) Node() Node {
+	return c.node
+}
+
+// Parent returns the parent of the current node.
+func (c *Cursor) Parent() Node {
+	if _, ok := c.parent.(*rootNode); ok {
+		return nil
+	}
+	return c.parent
+}
+
+// Name returns the name of the field of the parent holding the current node,
+// such as "Body" or "Lhs".
+func (c *Cursor) Name() string {
+	return c.name
+}
+
+// Index returns the index of the current node in the slice it is in, or -1 if
+// it is not in a slice.
+func (c *Cursor) Index() int {
+	if c.list == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace replaces the current node with n. The replacement is not walked.
+// Optional fields, such as the Init of a for loop or the ElseIf of an if
+// statement, are cleared by replacing their node with nil.
+func (c *Cursor) Replace(n Node) {
+	c.set(n)
+	c.node = n
+}
+
+// Delete deletes the current node from its slice. It panics if the node is not
+// in a slice.
+func (c *Cursor) Delete() {
+	if c.list == nil {
+		panic("Delete node not contained in slice")
+	}
+	c.list.delete(c.iter.index)
+	c.iter.step--
+}
+
+// InsertBefore inserts n before the current node in its slice. The new node
+// is not walked. It panics if the current node is not in a slice.
+func (c *Cursor) InsertBefore(n Node) {
+	if c.list == nil {
+		panic("InsertBefore node not contained in slice")
+	}
+	c.list.insert(c.iter.index, n)
+	c.iter.index++
+}
+
+// InsertAfter inserts n after the current node in its slice. The new node is
+// not walked. It panics if the current node is not in a slice.
+func (c *Cursor) InsertAfter(n Node) {
+	if c.list == nil {
+		panic("InsertAfter node not contained in slice")
+	}
+	c.list.insert(c.iter.index+1, n)
+	c.iter.step++
+}
+
+type iterator struct {
+	index int
+	step  int
+}
+
+type application struct {
+	pre    ApplyFunc
+	post   ApplyFunc
+	cursor Cursor
+	iter   iterator
+}
+
+func (a *application) apply(parent Node, name string, set func(Node), list nodeList, n Node) {
+	if isNil(n) {
+		return
+	}
+
+	saved := a.cursor
+	a.cursor = Cursor{parent: parent, name: name, node: n, set: set, list: list}
+	if list != nil {
+		a.cursor.iter = &a.iter
+	}
+
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	switch n := n.(type) {
+	case *Program:
+		a.applyList(n, "Items", (*astList)(&n.Items))
+	case *FunctionAST:
+		a.apply(n, "Prototype", func(r Node) { n.Prototype = r.(*PrototypeAST) }, nil, n.Prototype)
+		a.applyList(n, "Body", (*statementList)(&n.Body))
+	case *StatementAST:
+		a.apply(n, "AST", func(r Node) { n.AST = r.(AST) }, nil, n.AST)
+	case *AssignmentAST:
+		a.apply(n, "Expr", func(r Node) { n.Expr = r.(ExprAST) }, nil, n.Expr)
+	case *VarDeclAST:
+		a.apply(n, "Expr", func(r Node) { n.Expr, _ = r.(ExprAST) }, nil, n.Expr)
+	case *ReturnAST:
+		a.apply(n, "Expr", func(r Node) { n.Expr = r.(ExprAST) }, nil, n.Expr)
+	case *IfAST:
+		a.apply(n, "Cond", func(r Node) { n.Cond = r.(ExprAST) }, nil, n.Cond)
+		a.applyList(n, "IfBody", (*statementList)(&n.IfBody))
+		a.applyList(n, "ElseBody", (*statementList)(&n.ElseBody))
+		a.apply(n, "ElseIf", func(r Node) { n.ElseIf, _ = r.(*IfAST) }, nil, n.ElseIf)
+	case *WhileAST:
+		a.apply(n, "Cond", func(r Node) { n.Cond = r.(ExprAST) }, nil, n.Cond)
+		a.applyList(n, "Body", (*statementList)(&n.Body))
+	case *ForAST:
+		a.apply(n, "Init", func(r Node) { n.Init, _ = r.(AST) }, nil, n.Init)
+		a.apply(n, "Cond", func(r Node) { n.Cond, _ = r.(ExprAST) }, nil, n.Cond)
+		a.apply(n, "Step", func(r Node) { n.Step, _ = r.(AST) }, nil, n.Step)
+		a.applyList(n, "Body", (*statementList)(&n.Body))
+	case *ForRangeAST:
+		a.apply(n, "From", func(r Node) { n.From = r.(ExprAST) }, nil, n.From)
+		a.apply(n, "To", func(r Node) { n.To = r.(ExprAST) }, nil, n.To)
+		a.applyList(n, "Body", (*statementList)(&n.Body))
+	case *CallExprAST:
+		a.applyList(n, "Args", (*exprList)(&n.Args))
+	case *BinaryExprAST:
+		a.apply(n, "Lhs", func(r Node) { n.Lhs = r.(ExprAST) }, nil, n.Lhs)
+		a.apply(n, "Rhs", func(r Node) { n.Rhs = r.(ExprAST) }, nil, n.Rhs)
+	case *UnaryExprAST:
+		a.apply(n, "Operand", func(r Node) { n.Operand = r.(ExprAST) }, nil, n.Operand)
+	case *IfExprAST:
+		a.apply(n, "Cond", func(r Node) { n.Cond = r.(ExprAST) }, nil, n.Cond)
+		a.apply(n, "Then", func(r Node) { n.Then = r.(ExprAST) }, nil, n.Then)
+		a.apply(n, "Else", func(r Node) { n.Else = r.(ExprAST) }, nil, n.Else)
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abort)
+	}
+	a.cursor = saved
+}
+
+func (a *application) applyList(parent Node, name string, list nodeList) {
+	saved := a.iter
+	a.iter.index = 0
+	for a.iter.index < list.len() {
+		a.iter.step = 1
+		a.apply(parent, name, func(r Node) { list.set(a.iter.index, r) }, list, list.get(a.iter.index))
+		a.iter.index += a.iter.step
+	}
+	a.iter = saved
+}
+
+// nodeList is a slice of nodes being changed by Apply.
+type nodeList interface {
+	len() int
+	get(i int) Node
+	set(i int, n Node)
+	insert(i int, n Node)
+	delete(i int)
+}
+
+type astList []AST
+
+func (l *astList) len() int          { return len(*l) }
+func (l *astList) get(i int) Node    { return (*l)[i] }
+func (l *astList) set(i int, n Node) { (*l)[i] = n.(AST) }
+func (l *astList) insert(i int, n Node) {
+	*l = append((*l)[:i], append([]AST{n.(AST)}, (*l)[i:]...)...)
+}
+func (l *astList) delete(i int) { *l = append((*l)[:i], (*l)[i+1:]...) }
+
+type statementList []*StatementAST
+
+func (l *statementList) len() int          { return len(*l) }
+func (l *statementList) get(i int) Node    { return (*l)[i] }
+func (l *statementList) set(i int, n Node) { (*l)[i] = n.(*StatementAST) }
+func (l *statementList) insert(i int, n Node) {
+	*l = append((*l)[:i], append([]*StatementAST{n.(*StatementAST)}, (*l)[i:]...)...)
+}
+func (l *statementList) delete(i int) { *l = append((*l)[:i], (*l)[i+1:]...) }
+
+type exprList []ExprAST
+
+func (l *exprList) len() int          { return len(*l) }
+func (l *exprList) get(i int) Node    { return (*l)[i] }
+func (l *exprList) set(i int, n Node) { (*l)[i] = n.(ExprAST) }
+func (l *exprList) insert(i int, n Node) {
+	*l = append((*l)[:i], append([]ExprAST{n.(ExprAST)}, (*l)[i:]...)...)
+}
+func (l *exprList) delete(i int) { *l = append((*l)[:i], (*l)[i+1:]...) }
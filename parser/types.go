@@ -1,5 +1,10 @@
 package parser
 
+import (
+	"encoding/json"
+	"errors"
+)
+
 type Type int8
 
 const (
@@ -7,4 +12,51 @@ const (
 	Double       = iota
 	String       = iota
 	Void         = iota
+	Int          = iota
+	Bool         = iota
 )
+
+// NoType is the zero Type, of a declaration without a type annotation.
+const NoType Type = 0
+
+func (t Type) String() string {
+	switch t {
+	case Double:
+		return "double"
+	case String:
+		return "string"
+	case Void:
+		return "void"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	}
+	return "invalid"
+}
+
+func (t Type) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *Type) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	switch name {
+	case "double":
+		*t = Double
+	case "string":
+		*t = String
+	case "void":
+		*t = Void
+	case "int":
+		*t = Int
+	case "bool":
+		*t = Bool
+	default:
+		return errors.New("unknown type: " + name)
+	}
+	return nil
+}
@@ -1,29 +1,113 @@
 package parser
 
 import (
+	"Kaleidoscope/diag"
+	"Kaleidoscope/lexer"
 	"crypto/md5"
 	"encoding/hex"
-	"errors"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
+	"math"
+	"math/big"
 )
 
 var Module = ir.NewModule()
-var namedValues = map[*ir.Func]map[string]value.Value{
-	// Global vals
-	nil: {},
+
+// variable is a named value in a scope
+type variable struct {
+	val value.Value
+	// stored is set if val points to the storage of the variable rather than
+	// being its value
+	stored bool
+	// mutable is set if set may assign to the variable
+	mutable bool
+}
+
+// scope holds the variables declared in a block. The outermost scope holds
+// the global constants.
+type scope struct {
+	parent *scope
+	vars   map[string]*variable
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, vars: map[string]*variable{}}
+}
+
+// lookup returns the variable name visible in s, or nil.
+func (s *scope) lookup(name string) *variable {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+var globalScope = newScope(nil)
+
+// currentScope is the innermost scope of the code being generated
+var currentScope = globalScope
+
+// enterScope starts a new scope nested in the current one. The returned
+// function ends it.
+func enterScope() func() {
+	outer := currentScope
+	currentScope = newScope(outer)
+	return func() {
+		currentScope = outer
+	}
 }
 
-var opPrecedence = map[rune]int{
-	'=': 0,
-	'!': 0,
-	'<': 10,
-	'>': 10,
-	'+': 20,
-	'-': 20,
-	'*': 40,
+// Bitwise operators bind tighter than comparisons, so x & 1 == 0 compares
+// x & 1 with 0
+var opPrecedence = map[string]int{
+	"||": 2,
+	"&&": 4,
+	"==": 6,
+	"!=": 6,
+	"<":  10,
+	">":  10,
+	"<=": 10,
+	">=": 10,
+	"|":  11,
+	"^":  12,
+	"&":  13,
+	"<<": 15,
+	">>": 15,
+	"+":  20,
+	"-":  20,
+	"*":  40,
+	"/":  40,
+	"%":  40,
+	"**": 60,
+}
+
+// Binary operators are left associative except for these
+var rightAssocOps = map[string]bool{
+	"**": true,
+}
+
+// Compound assignment tokens and the binary operator they apply
+var assignOps = map[lexer.TokenKind]string{
+	lexer.TokPlusAssign:  "+",
+	lexer.TokMinusAssign: "-",
+	lexer.TokMulAssign:   "*",
+	lexer.TokDivAssign:   "/",
+}
+
+// isAssignOp reports whether op is the binary operator of a compound
+// assignment.
+func isAssignOp(op string) bool {
+	for _, binOp := range assignOps {
+		if binOp == op {
+			return true
+		}
+	}
+	return false
 }
 
 func getFunc(module *ir.Module, name string) *ir.Func {
@@ -35,23 +119,24 @@ func getFunc(module *ir.Module, name string) *ir.Func {
 	return nil
 }
 
-func retrieveVar(block *ir.Block, name string) (value.Value, error) {
+func retrieveVar(block *ir.Block, span diag.Span, name string) (value.Value, error) {
+	v := currentScope.lookup(name)
+
 	// STEP 0: Top level var = retrieve const
 	if block == nil {
-		return namedValues[nil][name], nil
+		if v != nil {
+			return v.val, nil
+		}
+		return nil, diag.Errorf("E0311", span, "could not identify const: %s", name)
 	}
 
-	// STEP 1: Check local block
-	if namedVar, ok := namedValues[block.Parent][name]; ok {
-		return load(block, namedVar), nil
+	if v == nil {
+		return nil, diag.Errorf("E0312", span, "could not identify var: %s", name)
 	}
-
-	// STEP 2: Check const
-	if val, ok := namedValues[nil][name]; ok {
-		return val, nil
+	if v.stored {
+		return load(block, v.val), nil
 	}
-
-	return nil, errors.New("could not identify var: " + name)
+	return v.val, nil
 }
 
 func load(block *ir.Block, namedVar value.Value) value.Value {
@@ -59,57 +144,154 @@ func load(block *ir.Block, namedVar value.Value) value.Value {
 	return block.NewLoad(getIRType(getType(namedVar)), namedVar)
 }
 
-func setVar(block *ir.Block, name string, val value.Value) error {
+// declareVar declares the variable name in the current scope with the initial
+// value val. At top level it declares a global constant.
+func declareVar(block *ir.Block, span diag.Span, name string, val value.Value, mutable bool) error {
+	if val.Type().Equal(types.Void) {
+		return diag.Errorf("E0313", span, "cannot assign void value to: %s", name)
+	}
+	if _, ok := currentScope.vars[name]; ok {
+		return diag.Errorf("E0328", span, "%s is already declared in this scope", name)
+	}
+
 	// STEP 0: Top level var = create global
 	if block == nil {
-		namedValues[nil][name] = val
-
 		// If expression isn't constant
 		if _, ok := val.(constant.Constant); !ok {
-			return errors.New(name + " is not equal to constant expression")
+			return diag.Errorf("E0314", span, "%s is not equal to constant expression", name)
 		}
 
+		currentScope.vars[name] = &variable{val: val}
 		return nil
 	}
 
-	// STEP 1: Check if local var exists
-	if block != nil {
-		if namedVar, ok := namedValues[block.Parent][name]; ok {
-			err := store(block, name, val, namedVar)
-			if err != nil {
-				return err
-			}
-			return nil
+	// Immutable variables need no storage
+	if !mutable {
+		currentScope.vars[name] = &variable{val: val}
+		return nil
+	}
+
+	// Allocate all variables in the entry block, so that a variable declared
+	// in a loop gets the same storage in every iteration
+	entry := block.Parent.Blocks[0]
+	newVar := entry.NewAlloca(val.Type())
+	currentScope.vars[name] = &variable{val: newVar, stored: true, mutable: true}
+	return store(block, span, name, val, newVar)
+}
+
+// checkType checks that val, the initial value of the variable name, has the
+// declared type typ, converting untyped number constants to it. NoType
+// accepts any type.
+func checkType(typ Type, val value.Value, span diag.Span, name string) (value.Value, error) {
+	if typ == NoType {
+		return val, nil
+	}
+	return checkValue(val, getIRType(typ), "E0329", span, "declaration of "+name)
+}
+
+// checkValue checks that val, used where a value of IR type want is expected,
+// has that type, converting untyped number constants to it. The error has the
+// given code and names the place where the value is used, such as "return
+// from f".
+func checkValue(val value.Value, want types.Type, code string, span diag.Span, place string) (value.Value, error) {
+	val = convertConst(val, want)
+	if !val.Type().Equal(want) {
+		err := diag.Errorf(code, span, "mismatched types in %s: expected %s, got %s",
+			place, typeOf(want), getType(val))
+		if getType(val) == Bool && (typeOf(want) == Double || typeOf(want) == Int) {
+			// Comparisons gave 0 or 1 before there was a bool type
+			err = err.WithNote("a bool is not converted to a number, use if c { 1 } else { 0 }")
 		}
+		return nil, err
+	}
+	return val, nil
+}
+
+// zeroValue returns the value of a variable of type typ declared without one.
+func zeroValue(block *ir.Block, typ Type) value.Value {
+	switch typ {
+	case Int:
+		return constant.NewInt(types.I64, 0)
+	case Bool:
+		return constant.NewBool(false)
+	case String:
+		val, _ := StringExprAST{}.CodeGen(block)
+		return val.(value.Value)
 	}
+	return constant.NewFloat(types.Double, 0)
+}
 
-	// STEP 2: Check if global exists
-	if _, ok := namedValues[nil][name]; ok {
-		return errors.New("cannot write to constant variable: " + name)
+// setVar assigns val to the variable name, which must have been declared. typ
+// is the type annotation of the set statement, or NoType if it has none.
+func setVar(block *ir.Block, span diag.Span, name string, typ Type, val value.Value) error {
+	if val.Type().Equal(types.Void) {
+		return diag.Errorf("E0313", span, "cannot assign void value to: %s", name)
 	}
 
-	// STEP 3: Create new local var
-	newVar := block.NewAlloca(val.Type())
-	namedValues[block.Parent][name] = newVar
-	return store(block, name, val, newVar)
+	v := currentScope.lookup(name)
+	if v == nil {
+		return diag.Errorf("E0326", span, "cannot assign to undeclared variable: %s", name).
+			WithNote("declare it with var " + name + " = ...")
+	}
+	if globalScope.vars[name] == v {
+		return diag.Errorf("E0315", span, "cannot write to constant variable: %s", name)
+	}
+	if !v.mutable {
+		return diag.Errorf("E0327", span, "cannot assign to immutable variable: %s", name).
+			WithNote("only variables declared with var can be assigned to")
+	}
+	if ptr, ok := v.val.Type().(*types.PointerType); ok && typ != NoType && typeOf(ptr.ElemType) != typ {
+		return diag.Errorf("E0225", span, "type annotation %s does not match the type of %s", typ, name).
+			WithNote(name + " is declared with type " + typeOf(ptr.ElemType).String())
+	}
+	return store(block, span, name, val, v.val)
 }
 
-func store(block *ir.Block, name string, val value.Value, namedVar value.Value) error {
+func store(block *ir.Block, span diag.Span, name string, val value.Value, namedVar value.Value) error {
 	if _, ok := namedVar.Type().(*types.PointerType); !ok {
-		return errors.New("cannot write to variable " + name)
+		return diag.Errorf("E0316", span, "cannot write to variable %s", name)
 	}
-	if !val.Type().Equal(namedVar.Type().(*types.PointerType).ElemType) {
-		return errors.New("cannot store incompatible type for: " + name)
+	val, err := checkValue(val, namedVar.Type().(*types.PointerType).ElemType, "E0317", span, "assignment to "+name)
+	if err != nil {
+		return err
 	}
 	block.NewStore(val, namedVar)
 	return nil
 }
 
-func IsOperator(chr int) bool {
-	_, ok := opPrecedence[rune(chr)]
+// builtinUnaryOps are the unary operators with built-in meaning. Other
+// operators call a function defined with def ... unary<op>(...).
+var builtinUnaryOps = map[string]bool{
+	"-": true,
+	"+": true,
+	"!": true,
+}
+
+// isUnaryOp reports whether tokens of the given kind are unary operators:
+// the built-in ones and ~, which has no meaning until it is defined.
+func isUnaryOp(kind lexer.TokenKind) bool {
+	switch kind {
+	case '-', '+', '!', '~':
+		return true
+	}
+	return false
+}
+
+// IsOperator reports whether tok is a binary operator.
+func IsOperator(tok lexer.Token) bool {
+	switch tok.Kind {
+	case lexer.TokIdentifier, lexer.TokNumVal, lexer.TokStringConst, lexer.TokError:
+		return false
+	}
+	_, ok := opPrecedence[tok.Text]
 	return ok
 }
 
+// isPunctuation reports whether tok is an operator or other punctuation.
+func isPunctuation(tok lexer.Token) bool {
+	return (tok.Kind > ' ' && tok.Kind < 0x7f) || lexer.IsMultiCharOp(tok.Text)
+}
+
 func getMD5Hash(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
@@ -121,24 +303,237 @@ func newBlock(block *ir.Block, name string) *ir.Block {
 	return block.Parent.NewBlock(newName)
 }
 
+// genStatements generates a block of statements in a new scope.
 func genStatements(block *ir.Block, stmts []*StatementAST) (*ir.Block, error) {
+	defer enterScope()()
 	for _, stmt := range stmts {
-		gen, err := stmt.CodeGen(block)
+		var err error
+		block, err = genStatement(block, stmt)
 		if err != nil {
 			return nil, err
 		}
+	}
+	return block, nil
+}
 
-		if retBlock, ok := gen.(*ir.Block); ok {
-			block = retBlock
-		}
+// genStatement generates stmt in block and returns the block that following
+// statements go in.
+func genStatement(block *ir.Block, stmt AST) (*ir.Block, error) {
+	gen, err := stmt.CodeGen(block)
+	if err != nil {
+		return nil, err
+	}
+
+	switch gen := gen.(type) {
+	case *ir.Block:
+		return gen, nil
+	case *blockValue:
+		return gen.block, nil
 	}
 	return block, nil
 }
 
+// blockValue is the result of generating an expression whose code ends in a
+// different block than it started in, such as an if expression. Code using
+// the value must go in block.
+type blockValue struct {
+	val   value.Value
+	block *ir.Block
+}
+
+// genExpr generates expr in block. It returns the value of expr and the block
+// that code using the value goes in.
+func genExpr(block *ir.Block, expr ExprAST) (value.Value, *ir.Block, error) {
+	gen, err := expr.CodeGen(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bv, ok := gen.(*blockValue); ok {
+		return bv.val, bv.block, nil
+	}
+	return gen.(value.Value), block, nil
+}
+
+// exprResult returns the result of CodeGen for an expression with value val
+// whose code starts in start and ends in end.
+func exprResult(val value.Value, start *ir.Block, end *ir.Block) interface{} {
+	if end != start {
+		return &blockValue{val: val, block: end}
+	}
+	return val
+}
+
+// convertConst converts val to an int constant if it is a number constant
+// with an integer value and typ is int, so that number literals and constants
+// can be used with ints. Other values are returned unchanged.
+func convertConst(val value.Value, typ types.Type) value.Value {
+	c, ok := val.(*constant.Float)
+	if !ok || !typ.Equal(types.I64) {
+		return val
+	}
+	if x, acc := c.X.Int64(); acc == big.Exact {
+		return constant.NewInt(types.I64, x)
+	}
+	return val
+}
+
+// foldNumbers evaluates the binary operator op on two number constants, so
+// that untyped constants such as 1 << 4 can be used where an int is expected.
+// The result is a number constant, or a bool constant for comparisons. The
+// int operators are only evaluated if both numbers have integer values. ok is
+// false if the operation was not evaluated.
+func foldNumbers(op string, l *constant.Float, r *constant.Float) (val value.Value, ok bool) {
+	x, y := floatValue(l), floatValue(r)
+	var z float64
+	switch op {
+	case "+":
+		z = x + y
+	case "-":
+		z = x - y
+	case "*":
+		z = x * y
+	case "/":
+		z = x / y
+	case "%":
+		z = math.Mod(x, y)
+	case "**":
+		z = math.Pow(x, y)
+	case "==":
+		return constant.NewBool(x == y), true
+	case "!=":
+		return constant.NewBool(x != y), true
+	case "<":
+		return constant.NewBool(x < y), true
+	case ">":
+		return constant.NewBool(x > y), true
+	case "<=":
+		return constant.NewBool(x <= y), true
+	case ">=":
+		return constant.NewBool(x >= y), true
+	default:
+		i, iok := l.X.Int64()
+		j, jok := r.X.Int64()
+		if l.NaN || r.NaN || iok != big.Exact || jok != big.Exact {
+			return nil, false
+		}
+		switch op {
+		case "&":
+			z = float64(i & j)
+		case "|":
+			z = float64(i | j)
+		case "^":
+			z = float64(i ^ j)
+		case "<<":
+			if j < 0 {
+				return nil, false
+			}
+			z = float64(i << uint64(j))
+		case ">>":
+			if j < 0 {
+				return nil, false
+			}
+			z = float64(i >> uint64(j))
+		default:
+			return nil, false
+		}
+	}
+	return constant.NewFloat(types.Double, z), true
+}
+
+// floatValue returns the value of the number constant c.
+func floatValue(c *constant.Float) float64 {
+	if c.NaN {
+		return math.NaN()
+	}
+	x, _ := c.X.Float64()
+	return x
+}
+
+// condition returns a bool value that is true if val, the value of the
+// condition at span, is true. Doubles are true if they are greater than 0 and
+// ints if they are not 0.
+func condition(block *ir.Block, span diag.Span, val value.Value) (value.Value, error) {
+	switch getType(val) {
+	case Bool:
+		return val, nil
+	case Int:
+		return block.NewICmp(enum.IPredNE, val, constant.NewInt(types.I64, 0)), nil
+	case Double:
+		return block.NewFCmp(enum.FPredOGT, val, constant.NewFloat(types.Double, 0.0)), nil
+	}
+	return nil, diag.Errorf("E0325", span, "condition must be bool, double or int")
+}
+
+// getIntrinsic returns the LLVM intrinsic function name, declaring it on first
+// use.
+func getIntrinsic(name string, retType types.Type, paramTypes ...types.Type) *ir.Func {
+	if theFunc := getFunc(Module, name); theFunc != nil {
+		return theFunc
+	}
+	params := make([]*ir.Param, len(paramTypes))
+	for i, typ := range paramTypes {
+		params[i] = ir.NewParam("", typ)
+	}
+	return Module.NewFunc(name, retType, params...)
+}
+
+// loop is a loop whose body is being generated
+type loop struct {
+	label string
+	// Blocks that break and continue branch to
+	breakBlock    *ir.Block
+	continueBlock *ir.Block
+}
+
+// loops are the loops around the statement being generated, innermost last
+var loops []*loop
+
+// genLoopBody generates the body of a loop, with break and continue branching
+// to the given blocks.
+func genLoopBody(block *ir.Block, label string, breakBlock *ir.Block, continueBlock *ir.Block, body []*StatementAST) (*ir.Block, error) {
+	loops = append(loops, &loop{label: label, breakBlock: breakBlock, continueBlock: continueBlock})
+	defer func() {
+		loops = loops[:len(loops)-1]
+	}()
+	return genStatements(block, body)
+}
+
+// findLoop returns the innermost loop, or the innermost loop with the given
+// label if it is not empty, for a break or continue statement.
+func findLoop(span diag.Span, keyword string, label string) (*loop, error) {
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label == "" || loops[i].label == label {
+			return loops[i], nil
+		}
+	}
+	if label != "" {
+		return nil, diag.Errorf("E0322", span, "%s to unknown loop label: %s", keyword, label)
+	}
+	return nil, diag.Errorf("E0321", span, "%s outside of a loop", keyword)
+}
+
+func labelString(label string) string {
+	if label == "" {
+		return ""
+	}
+	return label + ": "
+}
+
+func branchString(keyword string, label string) string {
+	if label == "" {
+		return keyword
+	}
+	return keyword + " " + label
+}
+
 func getIRType(typ Type) types.Type {
 	switch typ {
 	case Double:
 		return types.Double
+	case Int:
+		return types.I64
+	case Bool:
+		return types.I1
 	case String:
 		return types.NewPointer(types.I8)
 	case Void:
@@ -148,17 +543,37 @@ func getIRType(typ Type) types.Type {
 }
 
 func getType(val value.Value) Type {
-	t := val.Type()
-	if arrType, ok := t.(*types.ArrayType); ok {
+	return typeOf(val.Type())
+}
+
+// typeOf returns the type of values of IR type t. A pointer has the type of
+// what it points to, as variables are pointers to their storage.
+func typeOf(t types.Type) Type {
+	if t.Equal(types.Void) {
+		return Void
+	} else if arrType, ok := t.(*types.ArrayType); ok {
 		if arrType.ElemType == types.I8 {
 			return String
 		}
 	} else if _, ok := t.(*types.FloatType); ok {
 		return Double
+	} else if t.Equal(types.I64) {
+		return Int
+	} else if t.Equal(types.I1) {
+		return Bool
 	} else if ptrType, ok := t.(*types.PointerType); ok {
+		if ptrType.ElemType == types.I8 {
+			return String
+		}
 		if _, ok := ptrType.ElemType.(*types.FloatType); ok {
 			return Double
 		}
+		if ptrType.ElemType.Equal(types.I64) {
+			return Int
+		}
+		if ptrType.ElemType.Equal(types.I1) {
+			return Bool
+		}
 		if ptr2, ok := ptrType.ElemType.(*types.PointerType); ok {
 			if ptr2.ElemType == types.I8 {
 				return String
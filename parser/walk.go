@@ -0,0 +1,102 @@
+package parser
+
+import "Kaleidoscope/diag"
+
+// Node is any node of the syntax tree, including a *Program.
+type Node interface {
+	Span() diag.Span
+}
+
+// A Visitor's Visit method is called for each node encountered by Walk. If the
+// visitor w it returns is not nil, Walk visits each of the children of node
+// with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order. It starts by calling
+// v.Visit(node); node must not be nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, item := range n.Items {
+			walkIfNotNil(v, item)
+		}
+	case *FunctionAST:
+		walkIfNotNil(v, n.Prototype)
+		walkStatements(v, n.Body)
+	case *StatementAST:
+		walkIfNotNil(v, n.AST)
+	case *AssignmentAST:
+		walkIfNotNil(v, n.Expr)
+	case *VarDeclAST:
+		walkIfNotNil(v, n.Expr)
+	case *ReturnAST:
+		walkIfNotNil(v, n.Expr)
+	case *IfAST:
+		walkIfNotNil(v, n.Cond)
+		walkStatements(v, n.IfBody)
+		walkStatements(v, n.ElseBody)
+		walkIfNotNil(v, n.ElseIf)
+	case *WhileAST:
+		walkIfNotNil(v, n.Cond)
+		walkStatements(v, n.Body)
+	case *ForAST:
+		walkIfNotNil(v, n.Init)
+		walkIfNotNil(v, n.Cond)
+		walkIfNotNil(v, n.Step)
+		walkStatements(v, n.Body)
+	case *ForRangeAST:
+		walkIfNotNil(v, n.From)
+		walkIfNotNil(v, n.To)
+		walkStatements(v, n.Body)
+	case *CallExprAST:
+		for _, arg := range n.Args {
+			walkIfNotNil(v, arg)
+		}
+	case *BinaryExprAST:
+		walkIfNotNil(v, n.Lhs)
+		walkIfNotNil(v, n.Rhs)
+	case *UnaryExprAST:
+		walkIfNotNil(v, n.Operand)
+	case *IfExprAST:
+		walkIfNotNil(v, n.Cond)
+		walkIfNotNil(v, n.Then)
+		walkIfNotNil(v, n.Else)
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []*StatementAST) {
+	for _, stmt := range stmts {
+		walkIfNotNil(v, stmt)
+	}
+}
+
+func walkIfNotNil(v Visitor, node Node) {
+	if !isNil(node) {
+		Walk(v, node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order. It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of node, followed by a call of
+// f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,261 @@
+package parser
+
+import (
+	"Kaleidoscope/lexer"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const walkSrc = `extern double p(double d);
+def double f(double a) {
+	var x = a + 1;
+	p(x * 2);
+	for var i = 0; i < 3; set i += 1 { p(i); };
+	if x < 1 { return 1; } else if x < 2 { return -2; };
+	return x;
+}`
+
+// parseFunc parses src, which must hold a function definition as its last
+// item, and returns that function.
+func parseFunc(t *testing.T, src string) (*Program, *FunctionAST) {
+	prog, errs := NewParser(lexer.NewStringLexer("test.ks", src)).ParseProgram()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return prog, prog.Items[len(prog.Items)-1].(*FunctionAST)
+}
+
+// nodeName returns the type of n without its package and AST suffix.
+func nodeName(n Node) string {
+	return strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", n), "*parser."), "AST")
+}
+
+// describe lists the nodes in the body of fn in depth-first order, with the
+// values of numbers and the names of variables and called functions.
+func describe(fn *FunctionAST) string {
+	var names []string
+	for _, stmt := range fn.Body {
+		Inspect(stmt, func(n Node) bool {
+			switch n := n.(type) {
+			case nil:
+			case *NumberExprAST:
+				names = append(names, fmt.Sprint(n.Val))
+			case *VariableExprAST:
+				names = append(names, n.Name)
+			case *CallExprAST:
+				names = append(names, n.FuncName+"()")
+			default:
+				names = append(names, nodeName(n))
+			}
+			return true
+		})
+	}
+	return strings.Join(names, " ")
+}
+
+type countVisitor struct {
+	nodes int
+	nils  int
+}
+
+func (v *countVisitor) Visit(node Node) Visitor {
+	if node == nil {
+		v.nils++
+	} else {
+		v.nodes++
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	prog, _ := parseFunc(t, walkSrc)
+	v := &countVisitor{}
+	Walk(v, prog)
+	if v.nodes != v.nils {
+		t.Errorf("visited %d nodes but got %d nil visits", v.nodes, v.nils)
+	}
+	// The for loop without its body, an expression with a missing operand
+	for _, node := range []Node{&ForAST{Body: []*StatementAST{}}, &UnaryExprAST{Operator: &Operator{Op: "-"}}} {
+		v := &countVisitor{}
+		Walk(v, node)
+		if v.nodes != 1 || v.nils != 1 {
+			t.Errorf("%s: visited %d nodes and %d nils, want 1 and 1", nodeName(node), v.nodes, v.nils)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	_, fn := parseFunc(t, "def double f(double a) { if a < 1 { return -a; }; return p(a, 2); }")
+	tests := []struct {
+		name string
+		// prune stops descending into nodes of this kind
+		prune string
+		want  string
+	}{
+		{
+			name: "all",
+			want: "Function Prototype Statement If BinaryExpr VariableExpr NumberExpr Statement Return " +
+				"UnaryExpr VariableExpr Statement Return CallExpr VariableExpr NumberExpr",
+		},
+		{
+			name:  "prune if",
+			prune: "If",
+			want:  "Function Prototype Statement If Statement Return CallExpr VariableExpr NumberExpr",
+		},
+		{
+			name:  "prune statements",
+			prune: "Statement",
+			want:  "Function Prototype Statement Statement",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			depth := 0
+			Inspect(fn, func(n Node) bool {
+				if n == nil {
+					depth--
+					return false
+				}
+				names = append(names, nodeName(n))
+				if nodeName(n) == test.prune {
+					return false
+				}
+				depth++
+				return true
+			})
+			if got := strings.Join(names, " "); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+			if depth != 0 {
+				t.Errorf("got %d more nodes than nil calls", depth)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	call := func(name string) *StatementAST {
+		return &StatementAST{AST: &CallExprAST{FuncName: name, Args: []ExprAST{}}}
+	}
+	tests := []struct {
+		name string
+		pre  ApplyFunc
+		want string
+	}{
+		{
+			name: "replace",
+			pre: func(c *Cursor) bool {
+				if _, ok := c.Node().(*NumberExprAST); ok {
+					c.Replace(&NumberExprAST{Val: 42})
+				}
+				return true
+			},
+			want: "Statement VarDecl BinaryExpr a 42 Statement p() BinaryExpr x 42 " +
+				"Statement For VarDecl 42 BinaryExpr i 42 Assignment 42 Statement p() i " +
+				"Statement If BinaryExpr x 42 Statement Return 42 If BinaryExpr x 42 Statement Return UnaryExpr 42 " +
+				"Statement Return x",
+		},
+		{
+			name: "delete",
+			pre: func(c *Cursor) bool {
+				if stmt, ok := c.Node().(*StatementAST); ok {
+					if _, ok := stmt.AST.(*CallExprAST); ok {
+						c.Delete()
+					}
+				}
+				return true
+			},
+			want: "Statement VarDecl BinaryExpr a 1 " +
+				"Statement For VarDecl 0 BinaryExpr i 3 Assignment 1 " +
+				"Statement If BinaryExpr x 1 Statement Return 1 If BinaryExpr x 2 Statement Return UnaryExpr 2 " +
+				"Statement Return x",
+		},
+		{
+			name: "insert",
+			pre: func(c *Cursor) bool {
+				if stmt, ok := c.Node().(*StatementAST); ok {
+					switch stmt.AST.(type) {
+					case *ReturnAST:
+						c.InsertBefore(call("before"))
+					case *VarDeclAST:
+						c.InsertAfter(call("after"))
+					}
+				}
+				return true
+			},
+			want: "Statement VarDecl BinaryExpr a 1 Statement after() Statement p() BinaryExpr x 2 " +
+				"Statement For VarDecl 0 BinaryExpr i 3 Assignment 1 Statement p() i " +
+				"Statement If BinaryExpr x 1 Statement before() Statement Return 1 " +
+				"If BinaryExpr x 2 Statement before() Statement Return UnaryExpr 2 " +
+				"Statement before() Statement Return x",
+		},
+		{
+			name: "clear optional fields",
+			pre: func(c *Cursor) bool {
+				switch c.Name() {
+				case "Init", "Cond", "Step", "ElseIf":
+					if _, ok := c.Parent().(*ForAST); ok || c.Name() == "ElseIf" {
+						c.Replace(nil)
+					}
+				}
+				return true
+			},
+			want: "Statement VarDecl BinaryExpr a 1 Statement p() BinaryExpr x 2 " +
+				"Statement For Statement p() i " +
+				"Statement If BinaryExpr x 1 Statement Return 1 " +
+				"Statement Return x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog, fn := parseFunc(t, walkSrc)
+			if res := Apply(prog, test.pre, nil); res != prog {
+				t.Errorf("Apply returned a new root")
+			}
+			if got := describe(fn); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyRoot(t *testing.T) {
+	prog, _ := parseFunc(t, walkSrc)
+	empty := &Program{}
+	res := Apply(prog, func(c *Cursor) bool {
+		if c.Parent() == nil {
+			c.Replace(empty)
+			return false
+		}
+		return true
+	}, nil)
+	if res != empty {
+		t.Errorf("got root %v, want the replacement", res)
+	}
+
+	// Stop after the third node
+	n := 0
+	Apply(prog, nil, func(c *Cursor) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("post called %d times, want 3", n)
+	}
+}
+
+func TestApplyClearVarDecl(t *testing.T) {
+	_, fn := parseFunc(t, "def int f() { var x: int = 1; return x; }")
+	Apply(fn, func(c *Cursor) bool {
+		if _, ok := c.Parent().(*VarDeclAST); ok {
+			c.Replace(nil)
+		}
+		return true
+	}, nil)
+	decl := fn.Body[0].AST.(*VarDeclAST)
+	if decl.Expr != nil {
+		t.Errorf("got expression %v, want nil", decl.Expr)
+	}
+}